@@ -1,6 +1,6 @@
 var jayson = require('jayson');
 
-// create a client
+// create a client for a server started with -mode=http -addr=:9090
 var client = jayson.client.http({
   port: 9090
 });
@@ -5,14 +5,22 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/sourcegraph/tag-server/server"
 )
 
 var (
-	mode    = flag.String("mode", "stdio", "communication mode (stdio|tcp)")
-	addr    = flag.String("addr", ":2088", "server listen address (tcp)")
+	mode    = flag.String("mode", "stdio", "communication mode (stdio|tcp|http|websocket)")
+	addr    = flag.String("addr", "localhost:2088", "server listen address (tcp, http or websocket); give :2088 to listen on all interfaces")
 	logfile = flag.String("log", "/tmp/sample_server.log", "write log output to this file (and stderr)")
+
+	skipCommentRefs = flag.Bool("skip-comment-refs", true, "leave occurrences in comments and strings out of references")
+	workers         = flag.Int("workers", runtime.NumCPU(), "number of requests handled concurrently per connection")
+	origins         = flag.String("origins", "", "comma-separated origins allowed to open WebSockets or POST requests, or * for any (default same origin)")
+	timeout         = flag.Duration("timeout", 30*time.Second, "cancel requests that take longer than this (0 for no timeout)")
 )
 
 func main() {
@@ -23,8 +31,24 @@ func main() {
 		Mode:    *mode,
 		Addr:    *addr,
 		Logfile: *logfile,
+
+		IncludeCommentsAndStrings: !*skipCommentRefs,
+		Workers:                   *workers,
+		RequestTimeout:            *timeout,
+		AllowedOrigins:            splitList(*origins),
 	}); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -1,6 +1,7 @@
 package ctags
 
 import (
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -70,3 +71,37 @@ func (c *Config) Lang(filename string) string {
 	}
 	return c.extToLang[filepath.Ext(filename)]
 }
+
+// ListFiles walks root and returns the files that ctags maps to a
+// language, skipping the directories named in ignoreFiles.
+func ListFiles(root string) ([]string, error) {
+	cfg, err := getConfig()
+	if err != nil {
+		return nil, err
+	}
+	ignore := make(map[string]bool, len(ignoreFiles))
+	for _, name := range ignoreFiles {
+		ignore[name] = true
+	}
+
+	var files []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && (ignore[info.Name()] || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && cfg.Lang(info.Name()) != "" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
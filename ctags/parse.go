@@ -174,7 +174,7 @@ func (p *ETagsParser) parseLine(line string) error {
 	// Symbol line
 	lineNoIdx_ := strings.Index(line[nameIdx:], sepPos)
 	if lineNoIdx_ < 0 {
-		return fmt.Errorf("tags line parsing error: could not find character %U, line was %q", sepPos, line)
+		return fmt.Errorf("tags line parsing error: could not find character %q, line was %q", sepPos, line)
 	}
 	lineNoIdx := nameIdx + lineNoIdx_
 
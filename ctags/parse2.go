@@ -2,6 +2,7 @@ package ctags
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -20,11 +21,12 @@ type Tag struct {
 	// Extension fields
 	Access         string // "private", "public"
 	FileScope      string // ?
-	Inheritance    string // ?
+	Inheritance    string // "Base,Other", the classes a class extends or implements
 	Kind           string // "class"
 	Language       string // "Java"
 	Implementation string // ?
 	Line           int    // 23
+	End            int    // 42, the last line of the definition, if known
 	Scope          string // "enum:gl::foobar"
 	Signature      string // "(rtclass,objtype,obj,hr)"
 	Type           string // ?
@@ -76,66 +78,107 @@ func (p *TagsParser) parseLine(line string) error {
 	if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "!") {
 		return nil
 	}
+	tag, err := parseTagLine(line)
+	if err != nil {
+		return err
+	}
+	p.tags = append(p.tags, tag)
+	return nil
+}
 
+// parseTagLine parses a single non-pseudo line of a tags file. Besides
+// the output of Parse2, it accepts what ctags writes with its default
+// options: a bare kind letter instead of a "kind:" field, and a find
+// command instead of a "line:" field. When the find command is a
+// pattern, the returned tag's Line is 0.
+func parseTagLine(line string) (Tag, error) {
 	t1 := strings.Index(line, "\t")
 	if t1 == -1 {
-		return fmt.Errorf("expected tab-delimited line with at least 4 fields, but got %q", line)
+		return Tag{}, fmt.Errorf("expected tab-delimited line with at least 4 fields, but got %q", line)
 	}
 	name := line[0:t1]
 
 	t2_ := strings.Index(line[t1+1:], "\t")
 	if t2_ == -1 {
-		return fmt.Errorf("expected tab-delimited line with at least 4 fields, but got %q", line)
+		return Tag{}, fmt.Errorf("expected tab-delimited line with at least 4 fields, but got %q", line)
 	}
 	t2 := t1 + 1 + t2_
 	file := line[t1+1 : t2]
 
 	t3_ := strings.LastIndex(line[t2+1:], `;"`)
 	if t3_ == -1 {
-		return fmt.Errorf(`expected find command to terminate with ';"', but got %q`, line)
+		return Tag{}, fmt.Errorf(`expected find command to terminate with ';"', but got %q`, line)
 	}
 	t3 := t3_ + 2 + t2 + 1
-	if len(line) <= t3 || line[t3] != '\t' {
-		return fmt.Errorf(`expected tab immediately following ';"', but got %q, line: was %q`, line[t3:t3+1], line)
+	if len(line) > t3 && line[t3] != '\t' {
+		return Tag{}, fmt.Errorf(`expected tab immediately following ';"', but got %q, line: was %q`, line[t3:t3+1], line)
 	}
 	findCmd := line[t2+1 : t3]
 
-	extFields_ := strings.Split(line[t3+1:], "\t")
 	extFields := make(map[string]string)
-	for _, extField := range extFields_ {
-		s := strings.Index(extField, ":")
-		key, val := extField[0:s], extField[s+1:]
-		extFields[key] = val
+	if len(line) > t3 {
+		for _, extField := range strings.Split(line[t3+1:], "\t") {
+			s := strings.Index(extField, ":")
+			if s == -1 {
+				extFields["kind"] = extField
+				continue
+			}
+			key, val := extField[0:s], extField[s+1:]
+			extFields[key] = unescapeField(val)
+		}
 	}
-	lineno, err := strconv.Atoi(extFields["line"])
-	if err != nil {
-		return fmt.Errorf("could not parse line number, line was %q", line)
+	var lineno int
+	if lineField, ok := extFields["line"]; ok {
+		var err error
+		if lineno, err = strconv.Atoi(lineField); err != nil {
+			return Tag{}, fmt.Errorf("could not parse line number, line was %q", line)
+		}
+	} else if n, err := strconv.Atoi(strings.TrimSuffix(findCmd, `;"`)); err == nil {
+		lineno = n
 	}
 
-	p.tags = append(p.tags, Tag{
+	end, _ := strconv.Atoi(extFields["end"])
+
+	return Tag{
 		Name:          name,
 		File:          file,
 		DefLinePrefix: findCmdToDefLinePrefix(findCmd),
 		Access:        extFields["access"],
 		// FileScope:      string,
-		// Inheritance:    string,
-		Kind:     extFields["kind"],
-		Language: extFields["language"],
+		Inheritance: extFields["inherits"],
+		Kind:        extFields["kind"],
+		Language:    extFields["language"],
 		// Implementation: string,
 		Line:      lineno,
+		End:       end,
 		Scope:     extFields["scope"],
 		Signature: extFields["signature"],
 		Type:      extFields["typeref"],
-	})
-	return nil
+	}, nil
 }
 
+// findCmdToDefLinePrefix returns the source text matched by a pattern
+// find command, with ctags's escaping of the delimiter and backslashes
+// undone. It returns "" for line number find commands.
 func findCmdToDefLinePrefix(findCmd string) string {
-	def := strings.TrimSuffix(strings.TrimPrefix(findCmd, `/^`), `/;"`)
+	findCmd = strings.TrimSuffix(findCmd, `;"`)
+	if len(findCmd) < 2 || (findCmd[0] != '/' && findCmd[0] != '?') {
+		return ""
+	}
+	delim := findCmd[:1]
+	def := strings.TrimSuffix(strings.TrimPrefix(findCmd, delim+"^"), delim)
 	if strings.HasSuffix(def, "$") {
-		def = strings.TrimSuffix(def, "$")
+		// The $ is escaped, and literal, if an odd number of
+		// backslashes precede it, and otherwise anchors the end of
+		// the line.
+		n := len(def) - 1 - len(strings.TrimRight(def[:len(def)-1], `\`))
+		if n%2 == 1 {
+			def = def[:len(def)-2] + "$"
+		} else {
+			def = def[:len(def)-1]
+		}
 	}
-	return def
+	return strings.NewReplacer(`\\`, `\`, `\`+delim, delim).Replace(def)
 }
 
 func Parse2(files []string) (*TagsParser, error) {
@@ -175,3 +218,50 @@ func Parse2(files []string) (*TagsParser, error) {
 	}
 	return p, nil
 }
+
+// ParseFiles runs ctags over files and parses its output directly,
+// without writing a tags file. The file list is passed to ctags on
+// stdin, so it may be arbitrarily long.
+func ParseFiles(files []string) (*TagsParser, error) {
+	return ParseFilesContext(context.Background(), files)
+}
+
+// ParseFilesContext is like ParseFiles, but kills ctags if ctx is done
+// before it finishes.
+func ParseFilesContext(ctx context.Context, files []string) (*TagsParser, error) {
+	p, err := NewParser2()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return p, nil
+	}
+
+	cmd := exec.CommandContext(ctx, "ctags", "-f", "-", "--fields=*", "--excmd=pattern", "-L", "-")
+	cmd.Stdin = strings.NewReader(strings.Join(files, "\n"))
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("...running ctags on %d files", len(files))
+	ctagsStartTime := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if err := p.Parse(bufio.NewReader(out)); err != nil {
+		// Kill ctags rather than leave it blocked writing to a pipe
+		// nobody reads, which would make Wait hang.
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	log.Printf("...done running ctags (duration: %v)", time.Since(ctagsStartTime))
+	return p, nil
+}
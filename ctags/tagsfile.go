@@ -0,0 +1,239 @@
+package ctags
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Values of the !_TAG_FILE_SORTED pseudo-tag.
+const (
+	unsorted       = "0"
+	sorted         = "1"
+	foldcaseSorted = "2"
+)
+
+// TagsFile is a tags file that is searched in place instead of being
+// loaded into memory. Sorted files are searched by bisection, the way
+// vim and readtags look tags up; unsorted ones are scanned.
+type TagsFile struct {
+	f    *os.File
+	size int64
+	dir  string // directory relative file names are resolved against
+
+	// Pseudo holds the !_TAG_ pseudo-tags of the file, keyed by name
+	// without the "!_" prefix, e.g. "TAG_FILE_SORTED".
+	Pseudo map[string]string
+}
+
+// OpenTagsFile opens the tags file at path and reads its pseudo-tags.
+func OpenTagsFile(path string) (*TagsFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	t := &TagsFile{
+		f:      f,
+		size:   fi.Size(),
+		dir:    filepath.Dir(absPath),
+		Pseudo: make(map[string]string),
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, 0, t.size))
+	for {
+		line, err := r.ReadString('\n')
+		if !strings.HasPrefix(line, "!_") {
+			break
+		}
+		fields := strings.SplitN(strings.TrimRight(line, "\r\n"), "\t", 3)
+		if len(fields) >= 2 {
+			t.Pseudo[strings.TrimPrefix(fields[0], "!_")] = fields[1]
+		}
+		if err != nil {
+			break
+		}
+	}
+	return t, nil
+}
+
+func (t *TagsFile) Close() error {
+	return t.f.Close()
+}
+
+// Sorted reports whether the file declares itself sorted, and so can
+// be bisected.
+func (t *TagsFile) Sorted() bool {
+	s := t.Pseudo["TAG_FILE_SORTED"]
+	return s == sorted || s == foldcaseSorted
+}
+
+// Lookup returns the tags named name.
+func (t *TagsFile) Lookup(name string) ([]Tag, error) {
+	return t.search(name, false)
+}
+
+// LookupPrefix returns the tags whose name starts with prefix, up to
+// limit of them if limit is positive.
+func (t *TagsFile) LookupPrefix(prefix string, limit int) ([]Tag, error) {
+	tags, err := t.search(prefix, true)
+	if limit > 0 && len(tags) > limit {
+		tags = tags[:limit]
+	}
+	return tags, err
+}
+
+// search returns the tags named key, or starting with key if prefix is
+// set. In a sorted file these form a run that starts at the first name
+// not less than key.
+func (t *TagsFile) search(key string, prefix bool) ([]Tag, error) {
+	match := func(name string) bool { return name == key || (prefix && strings.HasPrefix(name, key)) }
+	foldcase := t.Pseudo["TAG_FILE_SORTED"] == foldcaseSorted
+	sortKey := key
+	if foldcase {
+		sortKey = strings.ToUpper(key)
+	}
+	var start int64
+	if t.Sorted() {
+		var err error
+		if start, err = t.bisect(sortKey, foldcase); err != nil {
+			return nil, err
+		}
+	}
+
+	var tags []Tag
+	r := bufio.NewReader(io.NewSectionReader(t.f, start, t.size-start))
+	for {
+		line, err := r.ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line != "" && !strings.HasPrefix(line, "!_") {
+			name := tagName(line)
+			if match(name) {
+				tag, perr := parseTagLine(line)
+				if perr != nil {
+					return nil, perr
+				}
+				tags = append(tags, t.resolve(tag))
+			} else if t.Sorted() {
+				if foldcase {
+					name = strings.ToUpper(name)
+				}
+				if name != sortKey && !(prefix && strings.HasPrefix(name, sortKey)) {
+					break
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// bisect returns the offset of the first line whose name is not less
+// than key.
+func (t *TagsFile) bisect(key string, foldcase bool) (int64, error) {
+	lo, hi := int64(0), t.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := t.lineAt(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= t.size {
+			hi = mid
+			continue
+		}
+		name := tagName(line)
+		if foldcase {
+			name = strings.ToUpper(name)
+		}
+		if strings.HasPrefix(line, "!_") || name < key {
+			lo = start + int64(len(line)) + 1
+		} else {
+			hi = mid
+		}
+	}
+	start, _, err := t.lineAt(lo)
+	return start, err
+}
+
+// lineAt returns the first line that starts at or after off, and its
+// offset. The offset is t.size if there is no such line.
+func (t *TagsFile) lineAt(off int64) (int64, string, error) {
+	r := bufio.NewReader(io.NewSectionReader(t.f, off, t.size-off))
+	start := off
+	if off > 0 {
+		// Skip to the end of the line off falls into, unless off is
+		// already at the start of a line.
+		var prev [1]byte
+		if _, err := t.f.ReadAt(prev[:], off-1); err != nil {
+			return 0, "", err
+		}
+		if prev[0] != '\n' {
+			skipped, err := r.ReadString('\n')
+			start += int64(len(skipped))
+			if err == io.EOF {
+				return t.size, "", nil
+			}
+			if err != nil {
+				return 0, "", err
+			}
+		}
+	}
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	return start, strings.TrimSuffix(line, "\n"), nil
+}
+
+// resolve makes the file of tag absolute and, for tags written with
+// line number or pattern-only find commands, fills in whichever of
+// Line and DefLinePrefix is missing from the source file.
+func (t *TagsFile) resolve(tag Tag) Tag {
+	if !filepath.IsAbs(tag.File) {
+		tag.File = filepath.Join(t.dir, tag.File)
+	}
+	if tag.Line > 0 && tag.DefLinePrefix != "" {
+		return tag
+	}
+	f, err := os.Open(tag.File)
+	if err != nil {
+		return tag
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		switch {
+		case tag.Line == n:
+			tag.DefLinePrefix = s.Text()
+			return tag
+		case tag.Line == 0 && tag.DefLinePrefix != "" && strings.HasPrefix(s.Text(), tag.DefLinePrefix):
+			tag.Line = n
+			return tag
+		}
+	}
+	return tag
+}
+
+func tagName(line string) string {
+	if i := strings.Index(line, "\t"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
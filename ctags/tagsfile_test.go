@@ -0,0 +1,160 @@
+package ctags
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testTagNames are the names in the tags files of TestTagsFile, with
+// filler names so that bisection takes several steps.
+func testTagNames() []string {
+	names := []string{"Alpha", "Beta", "Beta", "BetaMax", "Gamma", "alpha"}
+	for i := 0; i < 300; i++ {
+		names = append(names, fmt.Sprintf("m%03d", i))
+	}
+	return names
+}
+
+func writeTestTagsFile(t *testing.T, path, sortedFlag string, names []string) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "!_TAG_FILE_FORMAT\t2\t//\n")
+	fmt.Fprintf(&buf, "!_TAG_FILE_SORTED\t%s\t//\n", sortedFlag)
+	for i, name := range names {
+		fmt.Fprintf(&buf, "%s\t/src/f%d.go\t/^func %s() {$/;\"\tkind:function\tline:%d\n", name, i, name, i+1)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type byFoldedName []string
+
+func (s byFoldedName) Len() int           { return len(s) }
+func (s byFoldedName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byFoldedName) Less(i, j int) bool { return strings.ToUpper(s[i]) < strings.ToUpper(s[j]) }
+
+func TestTagsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagsfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sortedNames := testTagNames()
+	sort.Strings(sortedNames)
+	foldedNames := testTagNames()
+	sort.Stable(byFoldedName(foldedNames))
+	unsortedNames := testTagNames()
+	for i, j := 0, len(unsortedNames)-1; i < j; i, j = i+1, j-1 {
+		unsortedNames[i], unsortedNames[j] = unsortedNames[j], unsortedNames[i]
+	}
+
+	files := []struct {
+		sortedFlag string
+		names      []string
+		wantSorted bool
+	}{
+		{sorted, sortedNames, true},
+		{foldcaseSorted, foldedNames, true},
+		{unsorted, unsortedNames, false},
+	}
+	tests := []struct {
+		name   string
+		prefix bool
+		limit  int
+		want   int
+	}{
+		{"Alpha", false, 0, 1},
+		{"alpha", false, 0, 1},
+		{"Beta", false, 0, 2},
+		{"BetaMax", false, 0, 1},
+		{"Gamma", false, 0, 1},
+		{"m000", false, 0, 1},
+		{"m150", false, 0, 1},
+		{"m299", false, 0, 1},
+		{"Zed", false, 0, 0},
+		{"m30", false, 0, 0},
+		{"", false, 0, 0},
+
+		{"Beta", true, 0, 3},
+		{"Beta", true, 2, 2},
+		{"m1", true, 0, 100},
+		{"m29", true, 0, 10},
+		{"Al", true, 0, 1},
+		{"x", true, 0, 0},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, "tags"+f.sortedFlag)
+		writeTestTagsFile(t, path, f.sortedFlag, f.names)
+		tf, err := OpenTagsFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tf.Sorted() != f.wantSorted {
+			t.Errorf("sorted=%s: Sorted() = %v, want %v", f.sortedFlag, tf.Sorted(), f.wantSorted)
+		}
+		for _, test := range tests {
+			var tags []Tag
+			if test.prefix {
+				tags, err = tf.LookupPrefix(test.name, test.limit)
+			} else {
+				tags, err = tf.Lookup(test.name)
+			}
+			if err != nil {
+				t.Errorf("sorted=%s: looking up %q: %s", f.sortedFlag, test.name, err)
+				continue
+			}
+			if len(tags) != test.want {
+				t.Errorf("sorted=%s: looking up %q (prefix %v): got %d tags, want %d", f.sortedFlag, test.name, test.prefix, len(tags), test.want)
+			}
+			for _, tag := range tags {
+				if tag.Line == 0 || tag.DefLinePrefix != "func "+tag.Name+"() {" || tag.Kind != "function" {
+					t.Errorf("sorted=%s: looking up %q: got malformed tag %+v", f.sortedFlag, test.name, tag)
+				}
+			}
+		}
+		tf.Close()
+	}
+}
+
+func TestTagsFileResolvesRelativePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagsfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := "package a\n\nfunc Foo() {}\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tags := "!_TAG_FILE_SORTED\t1\t//\nFoo\ta.go\t/^func Foo() {}$/;\"\tkind:function\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "tags"), []byte(tags), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tf, err := OpenTagsFile(filepath.Join(dir, "tags"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tf.Close()
+
+	got, err := tf.Lookup("Foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d tags, want 1", len(got))
+	}
+	if want := filepath.Join(dir, "a.go"); got[0].File != want {
+		t.Errorf("File = %q, want %q", got[0].File, want)
+	}
+	if got[0].Line != 3 {
+		t.Errorf("Line = %d, want 3 (found from the pattern)", got[0].Line)
+	}
+}
@@ -0,0 +1,207 @@
+package ctags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteTags writes tags in the extended ctags format, sorted by name,
+// so that editors can bisect the result. File names are written
+// relative to base when base is non-empty.
+func WriteTags(w io.Writer, tags []Tag, base string) error {
+	tags = append([]Tag(nil), tags...)
+	sort.Stable(tagsByName(tags))
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "!_TAG_FILE_FORMAT\t2\t/extended format; --format=1 will not append ;\" to lines/\n")
+	fmt.Fprintf(bw, "!_TAG_FILE_SORTED\t%s\t/0=unsorted, 1=sorted, 2=foldcase/\n", sorted)
+	fmt.Fprintf(bw, "!_TAG_PROGRAM_NAME\tsrclib-ctags\t//\n")
+	for _, tag := range tags {
+		fmt.Fprintf(bw, "%s\t%s\t%s;\"", tag.Name, relPath(base, tag.File), defLinePrefixToFindCmd(tag))
+		var end string
+		if tag.End > 0 {
+			end = strconv.Itoa(tag.End)
+		}
+		for _, f := range []struct{ key, val string }{
+			{"kind", tag.Kind},
+			{"line", strconv.Itoa(tag.Line)},
+			{"end", end},
+			{"language", tag.Language},
+			{"scope", tag.Scope},
+			{"signature", tag.Signature},
+			{"typeref", tag.Type},
+			{"access", tag.Access},
+			{"inherits", tag.Inheritance},
+		} {
+			if f.val != "" {
+				fmt.Fprintf(bw, "\t%s:%s", f.key, escapeField(f.val))
+			}
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+// WriteETags writes tags in the emacs etags format, grouped by file.
+// The byte offsets etags needs are computed from the source files;
+// tags in unreadable files get an offset of 0.
+func WriteETags(w io.Writer, tags []Tag, base string) error {
+	var files []string
+	byFile := make(map[string][]Tag)
+	for _, tag := range tags {
+		if _, ok := byFile[tag.File]; !ok {
+			files = append(files, tag.File)
+		}
+		byFile[tag.File] = append(byFile[tag.File], tag)
+	}
+	sort.Strings(files)
+
+	bw := bufio.NewWriter(w)
+	for _, file := range files {
+		fileTags := byFile[file]
+		sort.Stable(tagsByLine(fileTags))
+		lineOffsets := fileLineOffsets(file)
+
+		var section bytes.Buffer
+		for _, tag := range fileTags {
+			var off int
+			if tag.Line > 0 && tag.Line <= len(lineOffsets) {
+				off = lineOffsets[tag.Line-1]
+			}
+			fmt.Fprintf(&section, "%s%s%s%s%d%s%d\n", tag.DefLinePrefix, sepTag, tag.Name, sepPos, tag.Line, sepCol, off)
+		}
+		fmt.Fprintf(bw, "\x0c\n%s,%d\n", relPath(base, file), section.Len())
+		section.WriteTo(bw)
+	}
+	return bw.Flush()
+}
+
+// jsonTag mirrors the tag objects of universal-ctags's JSON output
+// format.
+type jsonTag struct {
+	Type      string `json:"_type"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Pattern   string `json:"pattern,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	End       int    `json:"end,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ScopeKind string `json:"scopeKind,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Typeref   string `json:"typeref,omitempty"`
+	Access    string `json:"access,omitempty"`
+	Inherits  string `json:"inherits,omitempty"`
+}
+
+// WriteJSON writes tags as a stream of JSON objects, one per line, in
+// the layout of universal-ctags's --output-format=json.
+func WriteJSON(w io.Writer, tags []Tag, base string) error {
+	tags = append([]Tag(nil), tags...)
+	sort.Stable(tagsByName(tags))
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, tag := range tags {
+		jt := jsonTag{
+			Type:      "tag",
+			Name:      tag.Name,
+			Path:      relPath(base, tag.File),
+			Language:  tag.Language,
+			Line:      tag.Line,
+			End:       tag.End,
+			Kind:      tag.Kind,
+			Signature: tag.Signature,
+			Typeref:   tag.Type,
+			Access:    tag.Access,
+			Inherits:  tag.Inheritance,
+		}
+		if tag.DefLinePrefix != "" {
+			jt.Pattern = defLinePrefixToFindCmd(tag)
+		}
+		if i := strings.Index(tag.Scope, ":"); i >= 0 {
+			jt.ScopeKind, jt.Scope = tag.Scope[:i], tag.Scope[i+1:]
+		} else {
+			jt.Scope = tag.Scope
+		}
+		if err := enc.Encode(jt); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// defLinePrefixToFindCmd is the inverse of findCmdToDefLinePrefix. It
+// falls back to a line number find command when the tag has no
+// definition line. A trailing $ is escaped so that it is not read back
+// as an end-of-line anchor.
+func defLinePrefixToFindCmd(tag Tag) string {
+	if tag.DefLinePrefix == "" {
+		return strconv.Itoa(tag.Line)
+	}
+	pat := strings.NewReplacer(`\`, `\\`, `/`, `\/`).Replace(tag.DefLinePrefix)
+	if strings.HasSuffix(pat, "$") {
+		pat = pat[:len(pat)-1] + `\$`
+	}
+	return "/^" + pat + "/"
+}
+
+// escapeField escapes an extension field value the way ctags does.
+func escapeField(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`).Replace(s)
+}
+
+// unescapeField is the inverse of escapeField.
+func unescapeField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n").Replace(s)
+}
+
+func relPath(base, file string) string {
+	if base == "" {
+		return file
+	}
+	if rel, err := filepath.Rel(base, file); err == nil {
+		return rel
+	}
+	return file
+}
+
+// fileLineOffsets returns the byte offset of the start of each line of
+// file.
+func fileLineOffsets(file string) []int {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	offsets := []int{0}
+	for i, c := range b {
+		if c == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+type tagsByName []Tag
+
+func (t tagsByName) Len() int           { return len(t) }
+func (t tagsByName) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t tagsByName) Less(i, j int) bool { return t[i].Name < t[j].Name }
+
+type tagsByLine []Tag
+
+func (t tagsByLine) Len() int           { return len(t) }
+func (t tagsByLine) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t tagsByLine) Less(i, j int) bool { return t[i].Line < t[j].Line }
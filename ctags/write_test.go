@@ -0,0 +1,186 @@
+package ctags
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var writeTestTags = []Tag{
+	{
+		Name:          "Zeta",
+		File:          "/src/z.go",
+		DefLinePrefix: "func Zeta() {",
+		Kind:          "function",
+		Language:      "Go",
+		Line:          3,
+		End:           5,
+	},
+	{
+		Name:          "Alpha",
+		File:          "/src/a/b.go",
+		DefLinePrefix: `var Alpha = "a/b\c"`,
+		Kind:          "variable",
+		Language:      "Go",
+		Line:          7,
+	},
+	{
+		Name:          "method",
+		File:          "/src/c.py",
+		DefLinePrefix: "    def method(self,\ta):",
+		Kind:          "member",
+		Language:      "Python",
+		Scope:         "class:Base",
+		Signature:     "(self,\ta)",
+		Access:        "public",
+		Line:          12,
+	},
+	{
+		Name:        "Derived",
+		File:        "/src/c.py",
+		Kind:        "class",
+		Language:    "Python",
+		Inheritance: "Base,Other",
+		Type:        "typename:Derived",
+		Line:        20,
+	},
+	{
+		Name:          "price",
+		File:          "/src/d.pl",
+		DefLinePrefix: "my $price = $",
+		Kind:          "variable",
+		Language:      "Perl",
+		Line:          2,
+	},
+	{
+		Name:          "dollar",
+		File:          "/src/d.pl",
+		DefLinePrefix: `my $dollar = "\$`,
+		Kind:          "variable",
+		Language:      "Perl",
+		Line:          3,
+	},
+}
+
+func TestFindCmdRoundTrip(t *testing.T) {
+	for _, def := range []string{"a", "a$", `a\`, `a\$`, `a\\$`, "a/b", "$", `\`} {
+		cmd := defLinePrefixToFindCmd(Tag{DefLinePrefix: def}) + `;"`
+		if got := findCmdToDefLinePrefix(cmd); got != def {
+			t.Errorf("%q: wrote %s, read back %q", def, cmd, got)
+		}
+	}
+	// An unescaped trailing $ anchors the end of the line.
+	if got := findCmdToDefLinePrefix(`/^func a() {$/;"`); got != "func a() {" {
+		t.Errorf("anchored pattern read as %q", got)
+	}
+}
+
+func TestWriteTagsParse(t *testing.T) {
+	for _, base := range []string{"", "/src"} {
+		var buf bytes.Buffer
+		if err := WriteTags(&buf, writeTestTags, base); err != nil {
+			t.Fatal(err)
+		}
+
+		p := &TagsParser{langFiles: make(map[string][]string)}
+		if err := p.Parse(bufio.NewReader(&buf)); err != nil {
+			t.Fatalf("base %q: parsing written tags: %s", base, err)
+		}
+		got := p.Tags()
+
+		want := append([]Tag(nil), writeTestTags...)
+		for i := range want {
+			if base != "" {
+				want[i].File = strings.TrimPrefix(want[i].File, base+"/")
+			}
+		}
+		byName := make(map[string]Tag)
+		for _, tag := range want {
+			byName[tag.Name] = tag
+		}
+		if len(got) != len(want) {
+			t.Fatalf("base %q: got %d tags, want %d", base, len(got), len(want))
+		}
+		for i, tag := range got {
+			if i > 0 && got[i-1].Name > tag.Name {
+				t.Errorf("base %q: tags not sorted by name: %q before %q", base, got[i-1].Name, tag.Name)
+			}
+			if !reflect.DeepEqual(tag, byName[tag.Name]) {
+				t.Errorf("base %q: round trip of %s:\ngot  %+v\nwant %+v", base, tag.Name, tag, byName[tag.Name])
+			}
+		}
+	}
+}
+
+func TestWriteETagsParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "write-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := "package a\n\nvar x = 1\n\nfunc Foo() {\n}\n"
+	file := filepath.Join(dir, "a.go")
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tags := []Tag{
+		{Name: "Foo", File: file, DefLinePrefix: "func Foo() {", Line: 5},
+		{Name: "x", File: file, DefLinePrefix: "var x = 1", Line: 3},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteETags(&buf, tags, dir); err != nil {
+		t.Fatal(err)
+	}
+	p := &ETagsParser{config: &Config{}, langFiles: make(map[string][]string)}
+	if err := p.Parse(bufio.NewReader(&buf)); err != nil {
+		t.Fatalf("parsing written etags: %s", err)
+	}
+	want := []ETag{
+		{File: "a.go", Def: "var x = 1", Name: "x", Line: 3, ByteOff: strings.Index(src, "var x")},
+		{File: "a.go", Def: "func Foo() {", Name: "Foo", Line: 5, ByteOff: strings.Index(src, "func Foo")},
+	}
+	if got := p.Tags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, writeTestTags, "/src"); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]jsonTag)
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var jt jsonTag
+		if err := dec.Decode(&jt); err != nil {
+			t.Fatal(err)
+		}
+		got[jt.Name] = jt
+	}
+	if len(got) != len(writeTestTags) {
+		t.Fatalf("got %d JSON tags, want %d", len(got), len(writeTestTags))
+	}
+
+	m := got["method"]
+	if m.Type != "tag" || m.Path != "c.py" || m.Scope != "Base" || m.ScopeKind != "class" || m.Signature != "(self,\ta)" || m.Line != 12 {
+		t.Errorf("method: got %+v", m)
+	}
+	if m.Pattern != "/^    def method(self,\ta):/" {
+		t.Errorf("method: pattern %q", m.Pattern)
+	}
+	if d := got["Derived"]; d.Pattern != "" || d.Inherits != "Base,Other" || d.Typeref != "typename:Derived" {
+		t.Errorf("Derived: got %+v", d)
+	}
+	if a := got["Alpha"]; findCmdToDefLinePrefix(a.Pattern+`;"`) != writeTestTags[1].DefLinePrefix {
+		t.Errorf("Alpha: pattern %q does not round-trip", a.Pattern)
+	}
+}
@@ -0,0 +1,447 @@
+// Package index holds the tags of a workspace in memory and answers
+// exact, prefix, substring and fuzzy name lookups over them.
+package index
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+// Mode is the way a query name is matched against symbol names. Modes
+// are ordered from strictest to loosest, so a match found by a looser
+// mode also reports the strictest mode that would have found it.
+type Mode int
+
+const (
+	Exact     Mode = iota // name equals the query
+	Prefix                // name starts with the query
+	Substring             // name contains the query
+	Fuzzy                 // query is a subsequence of the name
+)
+
+// Query describes a symbol search.
+type Query struct {
+	Name string
+	Mode Mode
+
+	// IgnoreCase makes Exact, Prefix and Substring matching case
+	// insensitive. Fuzzy matching always ignores case.
+	IgnoreCase bool
+
+	Filter Filter
+
+	// Limit caps the number of results. Zero means no limit.
+	Limit int
+}
+
+// Filter restricts search results. Empty fields match everything.
+type Filter struct {
+	Kinds     []string // tag kinds, e.g. "function", "class"
+	Languages []string // ctags language names, compared case-insensitively
+	File      string   // glob matched against the path and each of its trailing subpaths
+	Scope     string   // name of the enclosing scope, e.g. "Foo" for "class:Foo"
+}
+
+// Match is a tag returned by a search.
+type Match struct {
+	ctags.Tag
+
+	// Mode is the strictest mode under which the tag's name matched.
+	Mode Mode
+}
+
+// Index is an in-memory symbol index. It is safe for concurrent use.
+type Index struct {
+	mu sync.RWMutex
+
+	files map[string][]ctags.Tag
+
+	// names maps a lowercased symbol name to every tag with that name.
+	names map[string][]ref
+
+	// trie holds the keys of names for prefix lookups.
+	trie *trieNode
+
+	// grams maps each trigram to the keys of names that contain it.
+	grams map[string]map[string]struct{}
+
+	// stamps records the state of each file on disk when it was last
+	// tagged by Update.
+	stamps map[string]Stamp
+
+	// gen counts the changes to the indexed tags.
+	gen uint64
+}
+
+type ref struct {
+	file string
+	i    int
+}
+
+func New() *Index {
+	return &Index{
+		files:  make(map[string][]ctags.Tag),
+		names:  make(map[string][]ref),
+		trie:   newTrieNode(),
+		grams:  make(map[string]map[string]struct{}),
+		stamps: make(map[string]Stamp),
+	}
+}
+
+// Add indexes tags, replacing any tags previously indexed for the
+// files they belong to. The files are considered stale by Update until
+// it next tags them from disk.
+func (ix *Index) Add(tags []ctags.Tag) {
+	byFile := make(map[string][]ctags.Tag)
+	var files []string
+	for _, tag := range tags {
+		if _, seen := byFile[tag.File]; !seen {
+			files = append(files, tag.File)
+		}
+		byFile[tag.File] = append(byFile[tag.File], tag)
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, file := range files {
+		ix.replace(file, byFile[file])
+		delete(ix.stamps, file)
+	}
+}
+
+// Replace sets the tags of file to tags. Passing no tags leaves the
+// file indexed with no symbols. Like Add, it marks file stale.
+func (ix *Index) Replace(file string, tags []ctags.Tag) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.replace(file, tags)
+	delete(ix.stamps, file)
+}
+
+// Remove drops file and its tags from the index.
+func (ix *Index) Remove(file string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(file)
+	delete(ix.files, file)
+	delete(ix.stamps, file)
+}
+
+// Files returns the sorted list of indexed files.
+func (ix *Index) Files() []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	files := make([]string, 0, len(ix.files))
+	for file := range ix.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// HasFile reports whether file is indexed, with or without tags.
+func (ix *Index) HasFile(file string) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	_, ok := ix.files[file]
+	return ok
+}
+
+// FileTags returns the tags indexed for file, in the order they were
+// added.
+func (ix *Index) FileTags(file string) []ctags.Tag {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return append([]ctags.Tag(nil), ix.files[file]...)
+}
+
+// Generation returns a number that changes whenever the indexed tags
+// do, so that callers can tell when data derived from them is stale.
+func (ix *Index) Generation() uint64 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.gen
+}
+
+// Len returns the number of indexed tags.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	n := 0
+	for _, tags := range ix.files {
+		n += len(tags)
+	}
+	return n
+}
+
+// Lookup returns the tags whose name is exactly name.
+func (ix *Index) Lookup(name string) []ctags.Tag {
+	matches := ix.Search(Query{Name: name, Mode: Exact})
+	tags := make([]ctags.Tag, len(matches))
+	for i, m := range matches {
+		tags[i] = m.Tag
+	}
+	return tags
+}
+
+// Search returns the tags matching q, ordered by match mode, then name
+// length, name, file and line.
+func (ix *Index) Search(q Query) []Match {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	key := strings.ToLower(q.Name)
+	var matches []Match
+	for _, name := range ix.candidates(key, q.Mode) {
+		for _, r := range ix.names[name] {
+			tag := ix.files[r.file][r.i]
+			mode, ok := matchName(tag.Name, q)
+			if !ok || !q.Filter.match(tag) {
+				continue
+			}
+			matches = append(matches, Match{Tag: tag, Mode: mode})
+		}
+	}
+
+	sort.Sort(byRank(matches))
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	return matches
+}
+
+// candidates returns the keys of ix.names that may match key under
+// mode. Callers must verify each candidate.
+func (ix *Index) candidates(key string, mode Mode) []string {
+	switch mode {
+	case Exact:
+		if _, ok := ix.names[key]; ok {
+			return []string{key}
+		}
+		return nil
+
+	case Prefix:
+		return ix.trie.withPrefix(key)
+
+	case Substring:
+		if grams := trigrams(key); len(grams) > 0 {
+			return ix.withTrigrams(grams)
+		}
+	}
+
+	names := make([]string, 0, len(ix.names))
+	for name := range ix.names {
+		names = append(names, name)
+	}
+	return names
+}
+
+// withTrigrams returns the names that contain every one of grams.
+func (ix *Index) withTrigrams(grams []string) []string {
+	// Start from the rarest trigram to keep the intersection small.
+	sets := make([]map[string]struct{}, len(grams))
+	for i, g := range grams {
+		sets[i] = ix.grams[g]
+		if len(sets[i]) == 0 {
+			return nil
+		}
+	}
+	sort.Sort(bySize(sets))
+
+	var names []string
+outer:
+	for name := range sets[0] {
+		for _, set := range sets[1:] {
+			if _, ok := set[name]; !ok {
+				continue outer
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (ix *Index) replace(file string, tags []ctags.Tag) {
+	ix.remove(file)
+	ix.gen++
+	tags = append([]ctags.Tag(nil), tags...)
+	ix.files[file] = tags
+	for i, tag := range tags {
+		key := strings.ToLower(tag.Name)
+		if _, ok := ix.names[key]; !ok {
+			ix.trie.insert(key)
+			for _, g := range trigrams(key) {
+				if ix.grams[g] == nil {
+					ix.grams[g] = make(map[string]struct{})
+				}
+				ix.grams[g][key] = struct{}{}
+			}
+		}
+		ix.names[key] = append(ix.names[key], ref{file: file, i: i})
+	}
+}
+
+// remove unlinks the tags of file from the name tables. It leaves
+// ix.files untouched.
+func (ix *Index) remove(file string) {
+	ix.gen++
+	for _, tag := range ix.files[file] {
+		key := strings.ToLower(tag.Name)
+		refs, ok := ix.names[key]
+		if !ok {
+			continue // already unlinked by an earlier tag with this name
+		}
+		kept := refs[:0]
+		for _, r := range refs {
+			if r.file != file {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) > 0 {
+			ix.names[key] = kept
+			continue
+		}
+		delete(ix.names, key)
+		ix.trie.remove(key)
+		for _, g := range trigrams(key) {
+			delete(ix.grams[g], key)
+			if len(ix.grams[g]) == 0 {
+				delete(ix.grams, g)
+			}
+		}
+	}
+}
+
+// matchName reports the strictest mode under which name matches q,
+// and whether it matches under q.Mode at all.
+func matchName(name string, q Query) (Mode, bool) {
+	if q.Mode == Fuzzy || q.IgnoreCase {
+		name, q.Name = strings.ToLower(name), strings.ToLower(q.Name)
+	}
+	var mode Mode
+	switch {
+	case name == q.Name:
+		mode = Exact
+	case strings.HasPrefix(name, q.Name):
+		mode = Prefix
+	case strings.Contains(name, q.Name):
+		mode = Substring
+	case isSubsequence(q.Name, name):
+		mode = Fuzzy
+	default:
+		return 0, false
+	}
+	return mode, mode <= q.Mode
+}
+
+// isSubsequence reports whether the runes of sub appear in s in order.
+func isSubsequence(sub, s string) bool {
+	for _, r := range sub {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+utf8.RuneLen(r):]
+	}
+	return true
+}
+
+// trigrams returns the distinct three-rune substrings of s.
+func trigrams(s string) []string {
+	runes := []rune(s)
+	if len(runes) < 3 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var grams []string
+	for i := 0; i+3 <= len(runes); i++ {
+		g := string(runes[i : i+3])
+		if !seen[g] {
+			seen[g] = true
+			grams = append(grams, g)
+		}
+	}
+	return grams
+}
+
+func (f *Filter) match(tag ctags.Tag) bool {
+	if len(f.Kinds) > 0 && !contains(f.Kinds, tag.Kind, false) {
+		return false
+	}
+	if len(f.Languages) > 0 && !contains(f.Languages, tag.Language, true) {
+		return false
+	}
+	if f.File != "" && !matchPath(f.File, tag.File) {
+		return false
+	}
+	if f.Scope != "" && scopeName(tag.Scope) != f.Scope {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string, ignoreCase bool) bool {
+	for _, v := range list {
+		if v == s || (ignoreCase && strings.EqualFold(v, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath reports whether glob matches path or any of its trailing
+// subpaths, so "*.go" and "server/*.go" both match "/src/server/lsp.go".
+func matchPath(glob, path string) bool {
+	path = filepath.ToSlash(path)
+	for {
+		if ok, _ := filepath.Match(glob, path); ok {
+			return true
+		}
+		i := strings.Index(path, "/")
+		if i < 0 {
+			return false
+		}
+		path = path[i+1:]
+	}
+}
+
+// scopeName strips the kind from a ctags scope field, returning "Foo"
+// for "class:Foo".
+func scopeName(scope string) string {
+	if i := strings.Index(scope, ":"); i >= 0 {
+		return scope[i+1:]
+	}
+	return scope
+}
+
+type bySize []map[string]struct{}
+
+func (s bySize) Len() int           { return len(s) }
+func (s bySize) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s bySize) Less(i, j int) bool { return len(s[i]) < len(s[j]) }
+
+type byRank []Match
+
+func (m byRank) Len() int      { return len(m) }
+func (m byRank) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byRank) Less(i, j int) bool {
+	a, b := m[i], m[j]
+	if a.Mode != b.Mode {
+		return a.Mode < b.Mode
+	}
+	if len(a.Name) != len(b.Name) {
+		return len(a.Name) < len(b.Name)
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	return a.Line < b.Line
+}
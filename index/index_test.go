@@ -0,0 +1,94 @@
+package index
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+var testTags = []ctags.Tag{
+	{Name: "parser", File: "/src/parse.go", Line: 3, Kind: "type", Language: "Go"},
+	{Name: "Parse", File: "/src/parse.go", Line: 10, Kind: "function", Language: "Go"},
+	{Name: "ParseFiles", File: "/src/parse.go", Line: 20, Kind: "function", Language: "Go"},
+	{Name: "newParser", File: "/src/new.go", Line: 5, Kind: "function", Language: "Go"},
+	{Name: "Print", File: "/lib/print.py", Line: 1, Kind: "function", Language: "Python"},
+	{Name: "reset", File: "/lib/print.py", Line: 7, Kind: "member", Language: "Python", Scope: "class:Printer"},
+}
+
+func TestSearch(t *testing.T) {
+	ix := New()
+	ix.Add(testTags)
+
+	tests := []struct {
+		q    Query
+		want []string // names, in result order
+	}{
+		{Query{Name: "Parse", Mode: Exact}, []string{"Parse"}},
+		{Query{Name: "parse", Mode: Exact}, nil},
+		{Query{Name: "parse", Mode: Exact, IgnoreCase: true}, []string{"Parse"}},
+		{Query{Name: "Parse", Mode: Prefix}, []string{"Parse", "ParseFiles"}},
+		{Query{Name: "parse", Mode: Prefix, IgnoreCase: true}, []string{"Parse", "parser", "ParseFiles"}},
+		{Query{Name: "arse", Mode: Substring}, []string{"Parse", "parser", "newParser", "ParseFiles"}},
+		{Query{Name: "Parse", Mode: Substring}, []string{"Parse", "ParseFiles", "newParser"}},
+		{Query{Name: "prs", Mode: Fuzzy}, []string{"Parse", "parser", "newParser", "ParseFiles"}},
+		{Query{Name: "pr", Mode: Fuzzy}, []string{"Print", "Parse", "parser", "newParser", "ParseFiles"}},
+		{Query{Name: "zzz", Mode: Fuzzy}, nil},
+
+		{Query{Name: "p", Mode: Fuzzy, Filter: Filter{Kinds: []string{"type"}}}, []string{"parser"}},
+		{Query{Name: "p", Mode: Fuzzy, Filter: Filter{Languages: []string{"python"}}}, []string{"Print"}},
+		{Query{Name: "", Mode: Prefix, Filter: Filter{File: "*.py"}}, []string{"Print", "reset"}},
+		{Query{Name: "", Mode: Prefix, Filter: Filter{Scope: "Printer"}}, []string{"reset"}},
+		{Query{Name: "Parse", Mode: Prefix, Limit: 1}, []string{"Parse"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, m := range ix.Search(test.q) {
+			got = append(got, m.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search(%+v): got %q, want %q", test.q, got, test.want)
+		}
+	}
+}
+
+func TestSearchReportsStrictestMode(t *testing.T) {
+	ix := New()
+	ix.Add(testTags)
+
+	want := map[string]Mode{
+		"Parse":      Exact,
+		"ParseFiles": Prefix,
+		"parser":     Prefix,
+		"newParser":  Substring,
+	}
+	for _, m := range ix.Search(Query{Name: "parse", Mode: Fuzzy}) {
+		if mode, ok := want[m.Name]; ok && m.Mode != mode {
+			t.Errorf("%s matched with mode %d, want %d", m.Name, m.Mode, mode)
+		}
+	}
+}
+
+func TestReplaceAndRemove(t *testing.T) {
+	ix := New()
+	ix.Add(testTags)
+
+	ix.Replace("/src/parse.go", []ctags.Tag{{Name: "Scan", File: "/src/parse.go", Line: 1}})
+	if got := ix.Lookup("Parse"); len(got) != 0 {
+		t.Errorf("after Replace, Lookup(Parse) = %v, want none", got)
+	}
+	if got := ix.Lookup("Scan"); len(got) != 1 {
+		t.Errorf("after Replace, Lookup(Scan) = %v, want 1 tag", got)
+	}
+	if got := ix.Search(Query{Name: "par", Mode: Prefix, IgnoreCase: true}); len(got) != 0 {
+		t.Errorf("after Replace, prefix search still finds %v", got)
+	}
+
+	ix.Remove("/src/parse.go")
+	if ix.HasFile("/src/parse.go") {
+		t.Error("after Remove, HasFile is true")
+	}
+	if got, want := ix.Len(), 3; got != want {
+		t.Errorf("after Remove, Len = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package index
+
+import (
+	"crypto/sha256"
+	"io"
+	"log"
+	"os"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+const hashSize = sha256.Size
+
+// Stamp identifies the contents of a file at the time it was tagged.
+type Stamp struct {
+	Size    int64
+	ModTime int64 // nanoseconds since the Unix epoch
+	Hash    [hashSize]byte
+}
+
+// fresh reports whether the file at path still has the contents
+// recorded by s. The hash is only computed when the size and
+// modification time disagree, and a touched but unchanged file has
+// its stamp refreshed in place.
+func (s *Stamp) fresh(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if fi.Size() == s.Size && fi.ModTime().UnixNano() == s.ModTime {
+		return true
+	}
+	cur, err := stampFile(path)
+	if err != nil || cur.Hash != s.Hash {
+		return false
+	}
+	*s = cur
+	return true
+}
+
+func stampFile(path string) (Stamp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Stamp{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return Stamp{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return Stamp{}, err
+	}
+	s := Stamp{Size: fi.Size(), ModTime: fi.ModTime().UnixNano()}
+	copy(s.Hash[:], h.Sum(nil))
+	return s, nil
+}
+
+// Update brings ix in line with files, the current file list of the
+// workspace. Indexed files missing from files are dropped, and files
+// that are new or whose contents changed since they were tagged are
+// re-tagged with tag. It returns the number of files re-tagged.
+func (ix *Index) Update(files []string, tag func(files []string) ([]ctags.Tag, error)) (int, error) {
+	want := make(map[string]bool, len(files))
+	for _, file := range files {
+		want[file] = true
+	}
+
+	ix.mu.Lock()
+	for file := range ix.files {
+		if !want[file] {
+			ix.remove(file)
+			delete(ix.files, file)
+			delete(ix.stamps, file)
+		}
+	}
+	stamps := make(map[string]Stamp, len(ix.stamps))
+	for file, s := range ix.stamps {
+		stamps[file] = s
+	}
+	ix.mu.Unlock()
+
+	stale := make(map[string]Stamp)
+	for _, file := range files {
+		if s, ok := stamps[file]; ok && s.fresh(file) {
+			stamps[file] = s
+			continue
+		}
+		// Stamp before tagging, so that a change made while ctags runs
+		// is picked up by the next Update.
+		s, err := stampFile(file)
+		if err != nil {
+			log.Printf("! skipping %s: %s", file, err)
+			continue
+		}
+		stale[file] = s
+	}
+
+	ix.mu.Lock()
+	for file, s := range stamps {
+		if _, ok := ix.stamps[file]; ok {
+			ix.stamps[file] = s
+		}
+	}
+	ix.mu.Unlock()
+
+	if len(stale) == 0 {
+		return 0, nil
+	}
+	staleFiles := make([]string, 0, len(stale))
+	for file := range stale {
+		staleFiles = append(staleFiles, file)
+	}
+	tags, err := tag(staleFiles)
+	if err != nil {
+		return 0, err
+	}
+	byFile := make(map[string][]ctags.Tag, len(stale))
+	for _, t := range tags {
+		byFile[t.File] = append(byFile[t.File], t)
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for file, s := range stale {
+		ix.replace(file, byFile[file])
+		ix.stamps[file] = s
+	}
+	return len(stale), nil
+}
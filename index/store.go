@@ -0,0 +1,311 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+// The on-disk index is a sequence of fixed-width little-endian
+// sections, laid out so that it could be read in place (e.g.
+// memory-mapped) without decoding, though Load does not do so yet:
+//
+//	header   magic [8]byte, version, nstrings, nfiles, ntags uint32, pad uint32
+//	strings  nstrings+1 uint32 offsets into the blob, then the blob,
+//	         padded to a multiple of 8 bytes
+//	files    nfiles fileRecords, sorted by path
+//	tags     ntags tagRecords, sorted by name, file and line
+//
+// Every string is interned in the string table and referenced by its
+// index, so repeated file names, kinds and scopes are stored once.
+const (
+	storeMagic   = "tagindex"
+	storeVersion = 2
+)
+
+// ErrVersion is returned by Load when the file was written by an
+// incompatible version of the index format.
+var ErrVersion = errors.New("index: unsupported on-disk format version")
+
+type storeHeader struct {
+	Magic    [8]byte
+	Version  uint32
+	NStrings uint32
+	NFiles   uint32
+	NTags    uint32
+	_        uint32
+}
+
+type fileRecord struct {
+	Path    uint32
+	_       uint32
+	Size    int64
+	ModTime int64
+	Hash    [hashSize]byte
+}
+
+type tagRecord struct {
+	Name, File, DefLinePrefix      uint32
+	Access, FileScope, Inheritance uint32
+	Kind, Language, Implementation uint32
+	Scope, Signature, Type         uint32
+	Line, End                      uint32
+}
+
+// Save writes ix to path, replacing any existing file atomically.
+func (ix *Index) Save(path string) error {
+	ix.mu.RLock()
+	var (
+		strs  = newInterner()
+		files []fileRecord
+		tags  []tagRecord
+	)
+	for file, fileTags := range ix.files {
+		stamp := ix.stamps[file]
+		files = append(files, fileRecord{
+			Path:    strs.id(file),
+			Size:    stamp.Size,
+			ModTime: stamp.ModTime,
+			Hash:    stamp.Hash,
+		})
+		for _, tag := range fileTags {
+			tags = append(tags, tagRecord{
+				Name:           strs.id(tag.Name),
+				File:           strs.id(tag.File),
+				DefLinePrefix:  strs.id(tag.DefLinePrefix),
+				Access:         strs.id(tag.Access),
+				FileScope:      strs.id(tag.FileScope),
+				Inheritance:    strs.id(tag.Inheritance),
+				Kind:           strs.id(tag.Kind),
+				Language:       strs.id(tag.Language),
+				Implementation: strs.id(tag.Implementation),
+				Scope:          strs.id(tag.Scope),
+				Signature:      strs.id(tag.Signature),
+				Type:           strs.id(tag.Type),
+				Line:           uint32(tag.Line),
+				End:            uint32(tag.End),
+			})
+		}
+	}
+	ix.mu.RUnlock()
+
+	sort.Sort(fileRecords{files, strs.strs})
+	sort.Sort(tagRecords{tags, strs.strs})
+
+	var buf bytes.Buffer
+	hdr := storeHeader{
+		Version:  storeVersion,
+		NStrings: uint32(len(strs.strs)),
+		NFiles:   uint32(len(files)),
+		NTags:    uint32(len(tags)),
+	}
+	copy(hdr.Magic[:], storeMagic)
+	binary.Write(&buf, binary.LittleEndian, &hdr)
+	var off uint32
+	for _, s := range strs.strs {
+		binary.Write(&buf, binary.LittleEndian, off)
+		off += uint32(len(s))
+	}
+	binary.Write(&buf, binary.LittleEndian, off)
+	for _, s := range strs.strs {
+		buf.WriteString(s)
+	}
+	for buf.Len()%8 != 0 {
+		buf.WriteByte(0)
+	}
+	binary.Write(&buf, binary.LittleEndian, files)
+	binary.Write(&buf, binary.LittleEndian, tags)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads an index written by Save. It decodes the whole file and
+// rebuilds the in-memory lookup tables (the names map, prefix trie and
+// trigram sets) from it. It does not memory-map the file, and the
+// lookup tables are not stored, so loading a large index still costs
+// time proportional to its size; it only saves re-running ctags.
+func Load(path string) (*Index, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(b)
+
+	var hdr storeHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("index: reading header of %s: %s", path, err)
+	}
+	if string(hdr.Magic[:]) != storeMagic {
+		return nil, fmt.Errorf("index: %s is not an index file", path)
+	}
+	if hdr.Version != storeVersion {
+		return nil, ErrVersion
+	}
+
+	// Check the counts against the file size before allocating, so
+	// that a corrupt header fails cleanly instead of wrapping around
+	// or asking for gigabytes.
+	need := (uint64(hdr.NStrings)+1)*4 +
+		uint64(hdr.NFiles)*uint64(binary.Size(fileRecord{})) +
+		uint64(hdr.NTags)*uint64(binary.Size(tagRecord{}))
+	if need > uint64(r.Len()) {
+		return nil, fmt.Errorf("index: %s is truncated or corrupt", path)
+	}
+
+	offsets := make([]uint32, uint64(hdr.NStrings)+1)
+	if err := binary.Read(r, binary.LittleEndian, offsets); err != nil {
+		return nil, fmt.Errorf("index: reading string table of %s: %s", path, err)
+	}
+	blobStart := len(b) - r.Len()
+	blobEnd := blobStart + int(offsets[hdr.NStrings])
+	if blobEnd > len(b) {
+		return nil, fmt.Errorf("index: string table of %s is truncated", path)
+	}
+	blob := string(b[blobStart:blobEnd])
+	strs := make([]string, hdr.NStrings)
+	for i := range strs {
+		if offsets[i] > offsets[i+1] || int(offsets[i+1]) > len(blob) {
+			return nil, fmt.Errorf("index: string table of %s is corrupt", path)
+		}
+		strs[i] = blob[offsets[i]:offsets[i+1]]
+	}
+	r.Seek(int64((blobEnd+7)/8*8), 0)
+
+	files := make([]fileRecord, hdr.NFiles)
+	if err := binary.Read(r, binary.LittleEndian, files); err != nil {
+		return nil, fmt.Errorf("index: reading files of %s: %s", path, err)
+	}
+	tags := make([]tagRecord, hdr.NTags)
+	if err := binary.Read(r, binary.LittleEndian, tags); err != nil {
+		return nil, fmt.Errorf("index: reading tags of %s: %s", path, err)
+	}
+
+	str := func(id uint32) (string, error) {
+		if int(id) >= len(strs) {
+			return "", fmt.Errorf("index: string %d out of range in %s", id, path)
+		}
+		return strs[id], nil
+	}
+
+	ix := New()
+	byFile := make(map[string][]ctags.Tag, len(files))
+	for _, rec := range files {
+		file, err := str(rec.Path)
+		if err != nil {
+			return nil, err
+		}
+		byFile[file] = nil
+		// Save writes a zero stamp for files that were never tagged
+		// from disk; leave those stale, as they were.
+		if stamp := (Stamp{Size: rec.Size, ModTime: rec.ModTime, Hash: rec.Hash}); stamp != (Stamp{}) {
+			ix.stamps[file] = stamp
+		}
+	}
+	for _, rec := range tags {
+		var tag ctags.Tag
+		for _, f := range []struct {
+			dst *string
+			id  uint32
+		}{
+			{&tag.Name, rec.Name},
+			{&tag.File, rec.File},
+			{&tag.DefLinePrefix, rec.DefLinePrefix},
+			{&tag.Access, rec.Access},
+			{&tag.FileScope, rec.FileScope},
+			{&tag.Inheritance, rec.Inheritance},
+			{&tag.Kind, rec.Kind},
+			{&tag.Language, rec.Language},
+			{&tag.Implementation, rec.Implementation},
+			{&tag.Scope, rec.Scope},
+			{&tag.Signature, rec.Signature},
+			{&tag.Type, rec.Type},
+		} {
+			if *f.dst, err = str(f.id); err != nil {
+				return nil, err
+			}
+		}
+		tag.Line, tag.End = int(rec.Line), int(rec.End)
+		byFile[tag.File] = append(byFile[tag.File], tag)
+	}
+	for file, fileTags := range byFile {
+		sort.Stable(byLine(fileTags))
+		ix.replace(file, fileTags)
+	}
+	return ix, nil
+}
+
+// interner assigns consecutive ids to distinct strings.
+type interner struct {
+	ids  map[string]uint32
+	strs []string
+}
+
+func newInterner() *interner {
+	return &interner{ids: make(map[string]uint32)}
+}
+
+func (in *interner) id(s string) uint32 {
+	if id, ok := in.ids[s]; ok {
+		return id
+	}
+	id := uint32(len(in.strs))
+	in.ids[s] = id
+	in.strs = append(in.strs, s)
+	return id
+}
+
+type fileRecords struct {
+	recs []fileRecord
+	strs []string
+}
+
+func (f fileRecords) Len() int           { return len(f.recs) }
+func (f fileRecords) Swap(i, j int)      { f.recs[i], f.recs[j] = f.recs[j], f.recs[i] }
+func (f fileRecords) Less(i, j int) bool { return f.strs[f.recs[i].Path] < f.strs[f.recs[j].Path] }
+
+type tagRecords struct {
+	recs []tagRecord
+	strs []string
+}
+
+func (t tagRecords) Len() int      { return len(t.recs) }
+func (t tagRecords) Swap(i, j int) { t.recs[i], t.recs[j] = t.recs[j], t.recs[i] }
+func (t tagRecords) Less(i, j int) bool {
+	a, b := t.recs[i], t.recs[j]
+	if a.Name != b.Name {
+		return t.strs[a.Name] < t.strs[b.Name]
+	}
+	if a.File != b.File {
+		return t.strs[a.File] < t.strs[b.File]
+	}
+	return a.Line < b.Line
+}
+
+type byLine []ctags.Tag
+
+func (t byLine) Len() int           { return len(t) }
+func (t byLine) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byLine) Less(i, j int) bool { return t[i].Line < t[j].Line }
@@ -0,0 +1,121 @@
+package index
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "index")
+
+	ix := New()
+	ix.Add(append(testTags, ctags.Tag{
+		Name:          "Weird",
+		File:          "/src/weird.go",
+		DefLinePrefix: "func Weird(a,\tb string)",
+		Signature:     "(a, b string)",
+		Type:          "typename:string",
+		Inheritance:   "Base,Other",
+		Access:        "public",
+		Line:          42,
+		End:           50,
+	}))
+	ix.Replace("/src/empty.go", nil)
+	ix.stamps["/src/parse.go"] = Stamp{Size: 123, ModTime: 456, Hash: [hashSize]byte{1, 2, 3}}
+
+	if err := ix.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got.Files(), ix.Files()) {
+		t.Errorf("files: got %q, want %q", got.Files(), ix.Files())
+	}
+	for _, file := range ix.Files() {
+		if g, w := got.FileTags(file), ix.FileTags(file); !reflect.DeepEqual(g, w) && !(len(g) == 0 && len(w) == 0) {
+			t.Errorf("tags of %s: got %+v, want %+v", file, g, w)
+		}
+	}
+	if !reflect.DeepEqual(got.stamps, ix.stamps) {
+		t.Errorf("stamps: got %+v, want %+v", got.stamps, ix.stamps)
+	}
+	if m := got.Search(Query{Name: "prs", Mode: Fuzzy}); len(m) != 4 {
+		t.Errorf("loaded index finds %d fuzzy matches, want 4", len(m))
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "index")
+
+	ix := New()
+	ix.Add(testTags)
+	if err := ix.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	good, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// header returns good with the header field at off replaced by v.
+	header := func(off int, v uint32) []byte {
+		b := append([]byte(nil), good...)
+		binary.LittleEndian.PutUint32(b[off:], v)
+		return b
+	}
+	const (
+		offVersion  = 8
+		offNStrings = 12
+		offNFiles   = 16
+		offNTags    = 20
+	)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short header", good[:10]},
+		{"header only", good[:binary.Size(storeHeader{})]},
+		{"truncated strings", good[:binary.Size(storeHeader{})+8]},
+		{"truncated tags", good[:len(good)-1]},
+		{"bad magic", append([]byte("notindex"), good[8:]...)},
+		{"max nstrings", header(offNStrings, 0xFFFFFFFF)},
+		{"huge nfiles", header(offNFiles, 1<<30)},
+		{"huge ntags", header(offNTags, 1<<30)},
+		{"zero nstrings", header(offNStrings, 0)},
+	}
+	for _, test := range tests {
+		if err := ioutil.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("%s: Load succeeded, want error", test.name)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, header(offVersion, storeVersion+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err != ErrVersion {
+		t.Errorf("other version: got error %v, want ErrVersion", err)
+	}
+}
@@ -0,0 +1,400 @@
+package index
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+// scopeSeparators lists the separators ctags uses between the
+// components of qualified scope names, per language. Languages not
+// listed are split on defaultScopeSeparators.
+var scopeSeparators = map[string][]string{
+	"C":          {"::", "."},
+	"C++":        {"::"},
+	"CUDA":       {"::"},
+	"Rust":       {"::"},
+	"Perl":       {"::"},
+	"Perl6":      {"::"},
+	"Tcl":        {"::"},
+	"PHP":        {`\`, "::"},
+	"Ruby":       {"::", "."},
+	"Clojure":    {"/", "."},
+	"Go":         {"."},
+	"Java":       {"."},
+	"C#":         {"."},
+	"Python":     {"."},
+	"JavaScript": {"."},
+	"TypeScript": {"."},
+	"Scala":      {"."},
+	"Kotlin":     {"."},
+}
+
+var defaultScopeSeparators = []string{"::", ".", "/"}
+
+// SplitScope splits a ctags scope field such as "enum:gl::foobar" into
+// its kind ("enum") and the components of its qualified name ("gl",
+// "foobar"), using the separators of lang.
+func SplitScope(scope, lang string) (kind string, path []string) {
+	if scope == "" {
+		return "", nil
+	}
+	if i := strings.Index(scope, ":"); i >= 0 && !strings.HasPrefix(scope[i:], "::") {
+		kind, scope = scope[:i], scope[i+1:]
+	}
+	seps, ok := scopeSeparators[lang]
+	if !ok {
+		seps = defaultScopeSeparators
+	}
+	path = []string{scope}
+	for _, sep := range seps {
+		var split []string
+		for _, p := range path {
+			split = append(split, strings.Split(p, sep)...)
+		}
+		path = split
+	}
+	return kind, path
+}
+
+// Node is a symbol in a scope tree.
+type Node struct {
+	Tag      ctags.Tag
+	Parent   *Node
+	Children []*Node
+
+	// End is the last line of the symbol: the tag's end line if ctags
+	// recorded one, and otherwise, for block-like symbols, the line
+	// before the next symbol in the file that it does not enclose.
+	End int
+
+	// Synthetic is set for nodes standing in for scopes that have no
+	// tag of their own, such as a class whose methods are tagged in a
+	// file other than the one defining it.
+	Synthetic bool
+}
+
+// Path returns the names of the nodes from the root of the tree down
+// to n.
+func (n *Node) Path() []string {
+	var path []string
+	for ; n != nil; n = n.Parent {
+		path = append(path, n.Tag.Name)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// TreePath returns the '/'-delimited path of n, in the form of srclib
+// def tree paths.
+func (n *Node) TreePath() string {
+	return strings.Join(n.Path(), "/")
+}
+
+// contains reports whether line falls within n's extent.
+func (n *Node) contains(line int) bool {
+	return !n.Synthetic && n.Tag.Line <= line && line <= n.End
+}
+
+// Tree is a forest of symbols nested by scope.
+type Tree struct {
+	Roots []*Node
+
+	// byPath maps a '/'-joined qualified name to its nodes.
+	byPath map[string][]*Node
+
+	// byFile holds the non-synthetic nodes of each file in line order.
+	byFile map[string][]*Node
+}
+
+// FileTree builds the scope tree of the tags of one file.
+func FileTree(tags []ctags.Tag) *Tree {
+	return buildTree(tags)
+}
+
+// FileTree builds the scope tree of the tags indexed for file.
+func (ix *Index) FileTree(file string) *Tree {
+	return buildTree(ix.FileTags(file))
+}
+
+// Tree builds the scope tree of every indexed tag, so that scopes
+// declared in one file collect the members defined in others.
+func (ix *Index) Tree() *Tree {
+	ix.mu.RLock()
+	var tags []ctags.Tag
+	for _, fileTags := range ix.files {
+		tags = append(tags, fileTags...)
+	}
+	ix.mu.RUnlock()
+	return buildTree(tags)
+}
+
+// Lookup returns the nodes with the given qualified name, written with
+// any of the separators of lang.
+func (t *Tree) Lookup(qualified, lang string) []*Node {
+	_, path := SplitScope(qualified, lang)
+	return t.byPath[strings.Join(path, "/")]
+}
+
+// Members returns the children of every node with the given qualified
+// name, e.g. "Foo" or "gl::foobar".
+func (t *Tree) Members(qualified, lang string) []*Node {
+	var members []*Node
+	for _, n := range t.Lookup(qualified, lang) {
+		members = append(members, n.Children...)
+	}
+	sort.Sort(nodesByPosition(members))
+	return members
+}
+
+// Enclosing returns the innermost symbol of file whose extent contains
+// line, or nil if there is none.
+func (t *Tree) Enclosing(file string, line int) *Node {
+	var enclosing *Node
+	for _, n := range t.byFile[file] {
+		if n.Tag.Line > line {
+			break
+		}
+		// Extents nest, so the last one to contain line is innermost.
+		if n.contains(line) {
+			enclosing = n
+		}
+	}
+	return enclosing
+}
+
+// Walk calls fn for each node in depth-first order, stopping early if
+// fn returns false. Each node is visited once, even if the tree has
+// been edited into a cycle.
+func (t *Tree) Walk(fn func(n *Node) bool) {
+	seen := make(map[*Node]bool)
+	var walk func(nodes []*Node) bool
+	walk = func(nodes []*Node) bool {
+		for _, n := range nodes {
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			if !fn(n) || !walk(n.Children) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(t.Roots)
+}
+
+func buildTree(tags []ctags.Tag) *Tree {
+	t := &Tree{
+		byPath: make(map[string][]*Node),
+		byFile: make(map[string][]*Node),
+	}
+
+	nodes := make([]*Node, len(tags))
+	for i, tag := range tags {
+		nodes[i] = &Node{Tag: tag}
+		t.byFile[tag.File] = append(t.byFile[tag.File], nodes[i])
+	}
+	for _, fileNodes := range t.byFile {
+		sort.Stable(nodesByPosition(fileNodes))
+	}
+
+	// Scoped tags name their parent explicitly. Resolve parents
+	// shallowest first, so that a parent's own path is known before
+	// its children look it up.
+	scoped := make([]*Node, 0, len(nodes))
+	for _, n := range nodes {
+		if n.Tag.Scope != "" {
+			scoped = append(scoped, n)
+		} else {
+			t.byPath[n.Tag.Name] = append(t.byPath[n.Tag.Name], n)
+		}
+	}
+	sort.Stable(nodesByScopeDepth(scoped))
+	for _, n := range scoped {
+		kind, path := SplitScope(n.Tag.Scope, n.Tag.Language)
+		n.Parent = t.scopeNode(kind, path, n.Tag)
+		key := strings.Join(append(path, n.Tag.Name), "/")
+		t.byPath[key] = append(t.byPath[key], n)
+	}
+
+	// Unscoped tags nest by line within the definitions that enclose
+	// them, when ctags recorded where those end, unless the enclosing
+	// definition is itself scoped within the tag, which would make a
+	// cycle.
+	for _, fileNodes := range t.byFile {
+		var open []*Node
+		for _, n := range fileNodes {
+			for len(open) > 0 && open[len(open)-1].Tag.End < n.Tag.Line {
+				open = open[:len(open)-1]
+			}
+			if n.Parent == nil && n.Tag.Scope == "" && len(open) > 0 && !isAncestor(n, open[len(open)-1]) {
+				n.Parent = open[len(open)-1]
+			}
+			if n.Tag.End > n.Tag.Line {
+				open = append(open, n)
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		if n.Parent == nil {
+			t.Roots = append(t.Roots, n)
+		} else {
+			n.Parent.Children = append(n.Parent.Children, n)
+		}
+	}
+	for _, fileNodes := range t.byFile {
+		computeEnds(fileNodes)
+	}
+	sort.Stable(nodesByPosition(t.Roots))
+	t.Walk(func(n *Node) bool {
+		sort.Stable(nodesByPosition(n.Children))
+		return true
+	})
+	return t
+}
+
+// scopeNode returns the node for the scope at path, preferring one
+// defined in the same file as child and of the given kind. Missing
+// scopes are created as synthetic nodes.
+func (t *Tree) scopeNode(kind string, path []string, child ctags.Tag) *Node {
+	key := strings.Join(path, "/")
+	var best *Node
+	bestScore := -1
+	for _, n := range t.byPath[key] {
+		score := 0
+		if n.Tag.File == child.File {
+			score += 2
+		}
+		if n.Tag.Kind == kind {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	if best != nil {
+		return best
+	}
+
+	n := &Node{
+		Tag:       ctags.Tag{Name: path[len(path)-1], Kind: kind, Language: child.Language},
+		Synthetic: true,
+	}
+	if len(path) > 1 {
+		n.Parent = t.scopeNode("", path[:len(path)-1], child)
+		n.Parent.Children = append(n.Parent.Children, n)
+	} else {
+		t.Roots = append(t.Roots, n)
+	}
+	t.byPath[key] = append(t.byPath[key], n)
+	return n
+}
+
+// computeEnds sets End on the nodes of a file, given in line order.
+// A node without a recorded end extends to the line before the next
+// node that it is not an ancestor of.
+func computeEnds(fileNodes []*Node) {
+	var open []*Node
+	for _, n := range fileNodes {
+		for len(open) > 0 && !isAncestor(open[len(open)-1], n) {
+			closeNode(open[len(open)-1], n.Tag.Line-1)
+			open = open[:len(open)-1]
+		}
+		open = append(open, n)
+	}
+	for _, n := range open {
+		closeNode(n, math.MaxInt32)
+	}
+}
+
+// blockKinds are the tag kinds whose definitions span a block of
+// lines, rather than the single line they are declared on.
+var blockKinds = map[string]bool{
+	"class":          true,
+	"constructor":    true,
+	"enum":           true,
+	"function":       true,
+	"func":           true,
+	"implementation": true,
+	"interface":      true,
+	"method":         true,
+	"module":         true,
+	"namespace":      true,
+	"package":        true,
+	"struct":         true,
+	"subroutine":     true,
+	"trait":          true,
+	"union":          true,
+}
+
+// closeNode sets the end of n to its recorded end. Failing that,
+// blocks extend to end, capped by the recorded end of their nearest
+// ancestor that has one, and other symbols end on their own line.
+func closeNode(n *Node, end int) {
+	if n.Tag.End > 0 {
+		n.End = n.Tag.End
+		return
+	}
+	if len(n.Children) == 0 && !blockKinds[n.Tag.Kind] {
+		n.End = n.Tag.Line
+		return
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Tag.End > 0 {
+			if p.Tag.End < end {
+				end = p.Tag.End
+			}
+			break
+		}
+	}
+	if end < n.Tag.Line {
+		end = n.Tag.Line
+	}
+	n.End = end
+}
+
+// isAncestor reports whether a is an ancestor of n. It gives up on
+// finding a cycle among n's ancestors: slow follows the parents at
+// half the pace of p, and they meet only if the chain loops.
+func isAncestor(a, n *Node) bool {
+	slow := n
+	for i, p := 0, n.Parent; p != nil; i, p = i+1, p.Parent {
+		if p == a {
+			return true
+		}
+		if i%2 == 1 {
+			slow = slow.Parent
+		}
+		if p == slow {
+			return false
+		}
+	}
+	return false
+}
+
+type nodesByPosition []*Node
+
+func (s nodesByPosition) Len() int      { return len(s) }
+func (s nodesByPosition) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s nodesByPosition) Less(i, j int) bool {
+	if s[i].Tag.File != s[j].Tag.File {
+		return s[i].Tag.File < s[j].Tag.File
+	}
+	return s[i].Tag.Line < s[j].Tag.Line
+}
+
+type nodesByScopeDepth []*Node
+
+func (s nodesByScopeDepth) Len() int      { return len(s) }
+func (s nodesByScopeDepth) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s nodesByScopeDepth) Less(i, j int) bool {
+	_, a := SplitScope(s[i].Tag.Scope, s[i].Tag.Language)
+	_, b := SplitScope(s[j].Tag.Scope, s[j].Tag.Language)
+	return len(a) < len(b)
+}
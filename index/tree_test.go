@@ -0,0 +1,189 @@
+package index
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+func TestSplitScope(t *testing.T) {
+	tests := []struct {
+		scope, lang string
+		kind        string
+		path        []string
+	}{
+		{"", "Go", "", nil},
+		{"class:Foo", "Python", "class", []string{"Foo"}},
+		{"class:a.b.C", "Java", "class", []string{"a", "b", "C"}},
+		{"enum:gl::foobar", "C++", "enum", []string{"gl", "foobar"}},
+		{"gl::foobar", "C++", "", []string{"gl", "foobar"}},
+		{"struct:a::b.c", "C", "struct", []string{"a", "b", "c"}},
+		{"struct:a.b", "C++", "struct", []string{"a.b"}},
+		{`namespace:App\Http::Kernel`, "PHP", "namespace", []string{"App", "Http", "Kernel"}},
+		{"module:A::B.c", "Ruby", "module", []string{"A", "B", "c"}},
+		{"namespace:foo.bar/baz", "Clojure", "namespace", []string{"foo", "bar", "baz"}},
+		{"type:a::b", "Go", "type", []string{"a::b"}},
+		{"package:a/b.c::d", "Lisp", "package", []string{"a", "b", "c", "d"}},
+	}
+	for _, test := range tests {
+		kind, path := SplitScope(test.scope, test.lang)
+		if kind != test.kind || !reflect.DeepEqual(path, test.path) {
+			t.Errorf("SplitScope(%q, %q) = %q, %q, want %q, %q", test.scope, test.lang, kind, path, test.kind, test.path)
+		}
+	}
+}
+
+func TestTreeNesting(t *testing.T) {
+	tree := FileTree([]ctags.Tag{
+		{Name: "gl", Kind: "namespace", File: "a.cpp", Language: "C++", Line: 1, End: 20},
+		{Name: "foobar", Kind: "enum", Scope: "namespace:gl", File: "a.cpp", Language: "C++", Line: 2, End: 5},
+		{Name: "X", Kind: "enumerator", Scope: "enum:gl::foobar", File: "a.cpp", Language: "C++", Line: 3},
+		{Name: "helper", Kind: "function", File: "a.cpp", Language: "C++", Line: 7, End: 8},
+		{Name: "local", Kind: "variable", File: "a.cpp", Language: "C++", Line: 8},
+		{Name: "method", Kind: "function", Scope: "class:Widget", File: "a.cpp", Language: "C++", Line: 10},
+		{Name: "after", Kind: "variable", File: "a.cpp", Language: "C++", Line: 30},
+	})
+
+	want := map[string]struct {
+		path      string
+		synthetic bool
+	}{
+		"gl":     {"gl", false},
+		"foobar": {"gl/foobar", false},
+		"X":      {"gl/foobar/X", false},
+		"helper": {"gl/helper", false},
+		"local":  {"gl/helper/local", false},
+		"Widget": {"Widget", true},
+		"method": {"Widget/method", false},
+		"after":  {"after", false},
+	}
+	seen := 0
+	tree.Walk(func(n *Node) bool {
+		seen++
+		w, ok := want[n.Tag.Name]
+		if !ok {
+			t.Errorf("unexpected node %s", n.TreePath())
+			return true
+		}
+		if n.TreePath() != w.path || n.Synthetic != w.synthetic {
+			t.Errorf("%s: path %q, synthetic %v, want %q, %v", n.Tag.Name, n.TreePath(), n.Synthetic, w.path, w.synthetic)
+		}
+		return true
+	})
+	if seen != len(want) {
+		t.Errorf("walked %d nodes, want %d", seen, len(want))
+	}
+
+	if got := tree.Lookup("gl::foobar", "C++"); len(got) != 1 || got[0].Tag.Kind != "enum" {
+		t.Errorf("Lookup(gl::foobar) = %v", got)
+	}
+	var members []string
+	for _, n := range tree.Members("gl", "C++") {
+		members = append(members, n.Tag.Name)
+	}
+	if want := []string{"foobar", "helper"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("Members(gl) = %q, want %q", members, want)
+	}
+}
+
+func TestTreeEnds(t *testing.T) {
+	tree := FileTree([]ctags.Tag{
+		{Name: "A", Kind: "class", File: "b.py", Language: "Python", Line: 1},
+		{Name: "m", Kind: "method", Scope: "class:A", File: "b.py", Language: "Python", Line: 2},
+		{Name: "n", Kind: "method", Scope: "class:A", File: "b.py", Language: "Python", Line: 5},
+		{Name: "B", Kind: "class", File: "b.py", Language: "Python", Line: 9, End: 11},
+		{Name: "k", Kind: "method", Scope: "class:B", File: "b.py", Language: "Python", Line: 10},
+		{Name: "CONST", Kind: "variable", File: "b.py", Language: "Python", Line: 13},
+		{Name: "f", Kind: "function", File: "b.py", Language: "Python", Line: 15},
+	})
+
+	ends := map[string]int{
+		"A":     8,  // up to B
+		"m":     4,  // up to its sibling
+		"n":     8,  // up to B
+		"B":     11, // as recorded
+		"k":     11, // capped by B's recorded end
+		"CONST": 13, // not a block
+		"f":     math.MaxInt32,
+	}
+	tree.Walk(func(n *Node) bool {
+		if n.End != ends[n.Tag.Name] {
+			t.Errorf("%s: End = %d, want %d", n.Tag.Name, n.End, ends[n.Tag.Name])
+		}
+		return true
+	})
+
+	tests := []struct {
+		file string
+		line int
+		want string // "" for none
+	}{
+		{"b.py", 1, "A"},
+		{"b.py", 3, "m"},
+		{"b.py", 6, "n"},
+		{"b.py", 9, "B"},
+		{"b.py", 12, ""},
+		{"b.py", 13, "CONST"},
+		{"b.py", 100, "f"},
+		{"other.py", 3, ""},
+	}
+	for _, test := range tests {
+		var got string
+		if n := tree.Enclosing(test.file, test.line); n != nil {
+			got = n.Tag.Name
+		}
+		if got != test.want {
+			t.Errorf("Enclosing(%s, %d) = %q, want %q", test.file, test.line, got, test.want)
+		}
+	}
+}
+
+func TestTreeScopeCycle(t *testing.T) {
+	// Outer's scope resolves to Inner, and Outer encloses Inner by
+	// line: nesting Inner in Outer as well would make a cycle.
+	tags := []ctags.Tag{
+		{Name: "Outer", Kind: "func", Scope: "Inner", File: "c.go", Language: "Go", Line: 1, End: 10},
+		{Name: "Inner", Kind: "func", File: "c.go", Language: "Go", Line: 5},
+	}
+	done := make(chan *Tree)
+	go func() { done <- FileTree(tags) }()
+	var tree *Tree
+	select {
+	case tree = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("building the tree does not terminate")
+	}
+
+	var paths []string
+	tree.Walk(func(n *Node) bool {
+		paths = append(paths, n.TreePath())
+		return true
+	})
+	if want := []string{"Inner", "Inner/Outer"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got paths %q, want %q", paths, want)
+	}
+}
+
+func TestCyclesTerminate(t *testing.T) {
+	a, b, c := &Node{}, &Node{}, &Node{}
+	a.Parent, b.Parent, c.Parent = b, a, a
+	a.Children, b.Children = []*Node{b, c}, []*Node{a}
+
+	if isAncestor(c, a) {
+		t.Error("isAncestor(c, a) = true")
+	}
+	if !isAncestor(b, c) {
+		t.Error("isAncestor(b, c) = false")
+	}
+	n := 0
+	(&Tree{Roots: []*Node{a}}).Walk(func(*Node) bool {
+		n++
+		return true
+	})
+	if n != 3 {
+		t.Errorf("walked %d nodes, want 3", n)
+	}
+}
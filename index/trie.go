@@ -0,0 +1,68 @@
+package index
+
+// trieNode is a node of a rune trie over lowercased symbol names.
+type trieNode struct {
+	children map[rune]*trieNode
+	terminal bool // a name ends at this node
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: make(map[rune]*trieNode)}
+}
+
+func (n *trieNode) insert(name string) {
+	for _, r := range name {
+		child, ok := n.children[r]
+		if !ok {
+			child = newTrieNode()
+			n.children[r] = child
+		}
+		n = child
+	}
+	n.terminal = true
+}
+
+// remove unmarks name and prunes the branches left without names.
+func (n *trieNode) remove(name string) {
+	runes := []rune(name)
+	path := make([]*trieNode, 0, len(runes)+1)
+	for _, r := range runes {
+		path = append(path, n)
+		child, ok := n.children[r]
+		if !ok {
+			return
+		}
+		n = child
+	}
+	n.terminal = false
+	for i := len(runes) - 1; i >= 0; i-- {
+		if n.terminal || len(n.children) > 0 {
+			return
+		}
+		n = path[i]
+		delete(n.children, runes[i])
+	}
+}
+
+// withPrefix returns every name in the trie that starts with prefix.
+func (n *trieNode) withPrefix(prefix string) []string {
+	for _, r := range prefix {
+		child, ok := n.children[r]
+		if !ok {
+			return nil
+		}
+		n = child
+	}
+	var names []string
+	n.collect([]rune(prefix), &names)
+	return names
+}
+
+func (n *trieNode) collect(prefix []rune, names *[]string) {
+	if n.terminal {
+		*names = append(*names, string(prefix))
+	}
+	for r, child := range n.children {
+		child.collect(append(prefix, r), names)
+	}
+}
@@ -0,0 +1,68 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+// CachePath returns where the index of the workspace at root is
+// persisted: under the user's cache dir, rather than in the workspace,
+// in a dir named for the root and a hash of its path.
+func CachePath(root string) string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	root = filepath.Clean(root)
+	sum := sha256.Sum256([]byte(root))
+	key := filepath.Base(root) + "-" + hex.EncodeToString(sum[:8])
+	return filepath.Join(dir, "tag-server", key, "index")
+}
+
+// OpenWorkspace returns an up-to-date index of the workspace at root.
+// It starts from the index persisted by an earlier run, if any,
+// re-tags only the files that changed since, and persists the result.
+func OpenWorkspace(root string) (*Index, error) {
+	start := time.Now()
+	path := CachePath(root)
+	ix, err := Load(path)
+	switch {
+	case err == nil:
+		log.Printf("...loaded %d tags from %s", ix.Len(), path)
+	case os.IsNotExist(err):
+		ix = New()
+	default:
+		log.Printf("! ignoring index at %s: %s", path, err)
+		ix = New()
+	}
+
+	files, err := ctags.ListFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	indexed := len(ix.Files())
+	n, err := ix.Update(files, func(files []string) ([]ctags.Tag, error) {
+		p, err := ctags.ParseFiles(files)
+		if err != nil {
+			return nil, err
+		}
+		return p.Tags(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("...indexed %s: %d files, %d re-tagged (duration: %v)", root, len(files), n, time.Since(start))
+
+	if n > 0 || indexed != len(ix.Files()) {
+		if err := ix.Save(path); err != nil {
+			log.Printf("! could not persist index to %s: %s", path, err)
+		}
+	}
+	return ix, nil
+}
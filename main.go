@@ -3,11 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
 )
 
 var (
@@ -112,3 +115,59 @@ func (c *DepresolveCmd) Execute(args []string) error {
 	fmt.Println("[]")
 	return nil
 }
+
+/*
+ * Export
+ */
+func init() {
+	_, err := flagParser.AddCommand("export",
+		"export the symbol index as a tags file",
+		"Export the workspace symbol index as a ctags, etags or JSON tags file.",
+		&exportCmd,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type ExportCmd struct {
+	Format string `long:"format" description:"output format" choice:"ctags" choice:"etags" choice:"json" default:"ctags"`
+	Output string `short:"o" long:"output" description:"file to write to; if empty, writes to stdout"`
+}
+
+var exportCmd ExportCmd
+
+func (c *ExportCmd) Execute(args []string) error {
+	ix, err := index.OpenWorkspace(cwd)
+	if err != nil {
+		return err
+	}
+	var tags []ctags.Tag
+	for _, file := range ix.Files() {
+		tags = append(tags, ix.FileTags(file)...)
+	}
+
+	// File names are written relative to the tags file, which is
+	// how editors resolve them.
+	w, base := io.Writer(os.Stdout), cwd
+	if c.Output != "" {
+		f, err := os.Create(c.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+		if base, err = filepath.Abs(filepath.Dir(c.Output)); err != nil {
+			return err
+		}
+	}
+
+	switch c.Format {
+	case "etags":
+		return ctags.WriteETags(w, tags, base)
+	case "json":
+		return ctags.WriteJSON(w, tags, base)
+	default:
+		return ctags.WriteTags(w, tags, base)
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sourcegraph/tag-server/ctags"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// showReferences is the client command lenses run to list locations.
+// Its arguments are the document URI, the position the list is for and
+// the locations.
+const showReferences = "editor.action.showReferences"
+
+// noLensKinds are the tag kinds that get no code lenses: symbols that
+// are only visible locally or are not definitions.
+var noLensKinds = map[string]bool{
+	"local":     true,
+	"parameter": true,
+	"label":     true,
+	"import":    true,
+	"prototype": true,
+	"externvar": true,
+	"file":      true,
+}
+
+// Lens types.
+const (
+	lensReferences      = "references"
+	lensImplementations = "implementations"
+)
+
+// codeLensData is what an unresolved code lens carries to
+// codeLens/resolve.
+type codeLensData struct {
+	Type string `json:"type"` // lensReferences or lensImplementations
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Name string `json:"name"`
+}
+
+// codeLenses returns the unresolved lenses of the definitions in tags.
+func codeLenses(tags []ctags.Tag) []lsp.CodeLens {
+	var lenses []lsp.CodeLens
+	for _, sym := range tagsToSymbolInformation(tags) {
+		tag := tagAt(tags, sym)
+		if noLensKinds[tag.Kind] {
+			continue
+		}
+		lenses = append(lenses, lsp.CodeLens{
+			Range: sym.Location.Range,
+			Data:  codeLensData{Type: lensReferences, File: tag.File, Line: tag.Line, Name: tag.Name},
+		})
+		if typeKinds[tag.Kind] {
+			lenses = append(lenses, lsp.CodeLens{
+				Range: sym.Location.Range,
+				Data:  codeLensData{Type: lensImplementations, File: tag.File, Line: tag.Line, Name: tag.Name},
+			})
+		}
+	}
+	return lenses
+}
+
+// tagAt returns the tag a symbol was made from.
+func tagAt(tags []ctags.Tag, sym lsp.SymbolInformation) ctags.Tag {
+	for _, tag := range tags {
+		if tag.Name == sym.Name && tag.Line-1 == sym.Location.Range.Start.Line {
+			return tag
+		}
+	}
+	return ctags.Tag{}
+}
+
+// resolveReferencesLens counts the references to the symbol of a lens,
+// leaving out the declarations of symbols of the same name.
+func (s *LangSvc) resolveReferencesLens(ctx context.Context, data codeLensData) ([]lsp.Location, error) {
+	ix := s.index()
+	decls := make(map[string]map[int]int)
+	if ix != nil {
+		decls = declarationSites(ix.Lookup(data.Name))
+	}
+	occs, err := s.occurrences(ctx, ix, data.File, data.Name, s.SkipCommentsAndStrings)
+	if err != nil {
+		return nil, err
+	}
+	locs := []lsp.Location{}
+	for _, occ := range occs {
+		if col, ok := decls[occ.File][occ.Range.Start.Line+1]; ok && occ.Col >= col {
+			delete(decls[occ.File], occ.Range.Start.Line+1)
+			continue
+		}
+		if occ.File == data.File && occ.Range.Start.Line+1 == data.Line {
+			// The definition itself, when the index has not caught
+			// up with the document.
+			continue
+		}
+		locs = append(locs, occ.Location())
+	}
+	return locs, nil
+}
+
+// resolveImplementationsLens lists the types extending or implementing
+// the type of a lens.
+func (s *LangSvc) resolveImplementationsLens(data codeLensData) []lsp.Location {
+	ix := s.index()
+	if ix == nil {
+		return []lsp.Location{}
+	}
+	locs := make([]lsp.Location, 0)
+	for _, sym := range tagsToSymbolInformation(s.workspace.inheritanceGraph().allSubtypes(data.Name)) {
+		locs = append(locs, sym.Location)
+	}
+	return locs
+}
+
+// countTitle returns e.g. "1 reference" or "3 references".
+func countTitle(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", strings.TrimSuffix(noun, "s"))
+	}
+	return fmt.Sprintf("%d %s", n, noun)
+}
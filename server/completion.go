@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// maxCompletionItems caps the items of a completion list. Lists that
+// are cut short are marked incomplete, so that the client asks again
+// as the prefix grows.
+const maxCompletionItems = 100
+
+var nameToCompletionItemKind = map[string]lsp.CompletionItemKind{
+	"class":       lsp.CIKClass,
+	"struct":      lsp.CIKClass,
+	"union":       lsp.CIKClass,
+	"typedef":     lsp.CIKClass,
+	"type":        lsp.CIKClass,
+	"interface":   lsp.CIKInterface,
+	"trait":       lsp.CIKInterface,
+	"protocol":    lsp.CIKInterface,
+	"enum":        lsp.CIKEnum,
+	"enumerator":  lsp.CIKValue,
+	"constant":    lsp.CIKValue,
+	"const":       lsp.CIKValue,
+	"macro":       lsp.CIKValue,
+	"define":      lsp.CIKValue,
+	"method":      lsp.CIKMethod,
+	"function":    lsp.CIKFunction,
+	"func":        lsp.CIKFunction,
+	"subroutine":  lsp.CIKFunction,
+	"prototype":   lsp.CIKFunction,
+	"constructor": lsp.CIKConstructor,
+	"field":       lsp.CIKField,
+	"member":      lsp.CIKField,
+	"property":    lsp.CIKProperty,
+	"variable":    lsp.CIKVariable,
+	"var":         lsp.CIKVariable,
+	"local":       lsp.CIKVariable,
+	"parameter":   lsp.CIKVariable,
+	"module":      lsp.CIKModule,
+	"namespace":   lsp.CIKModule,
+	"package":     lsp.CIKModule,
+	"file":        lsp.CIKFile,
+}
+
+// tagRef identifies a tag in the data of protocol objects that the
+// client hands back for resolution, such as completion items.
+type tagRef struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Name string `json:"name"`
+}
+
+// completionPrefix returns the part of the identifier that ends at
+// character c of line, and where it starts.
+func completionPrefix(line string, c int, syn syntax) (string, int) {
+	if c > len(line) {
+		c = len(line)
+	}
+	start := strings.LastIndexFunc(line[:c], func(r rune) bool { return !syn.isIdent(r) }) + 1
+	return line[start:c], start
+}
+
+// completionTags returns the tags starting with prefix: from the index,
+// and for the document at docPath from its unsaved contents if it has
+// any. The match is case-insensitive unless prefix has upper case
+// letters.
+func (s *LangSvc) completionTags(ctx context.Context, ix *index.Index, docPath, prefix string) []ctags.Tag {
+	ignoreCase := strings.IndexFunc(prefix, unicode.IsUpper) < 0
+	matches := ix.Search(index.Query{Name: prefix, Mode: index.Prefix, IgnoreCase: ignoreCase})
+	tags := make([]ctags.Tag, len(matches))
+	for i, m := range matches {
+		tags[i] = m.Tag
+	}
+
+	bufTags, ok, err := s.overlay.dirtyTags(ctx, docPath)
+	if err != nil {
+		return tags
+	}
+	if ok {
+		tags = withoutFile(tags, docPath)
+		for _, tag := range bufTags {
+			if strings.HasPrefix(tag.Name, prefix) || ignoreCase && strings.HasPrefix(strings.ToLower(tag.Name), prefix) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// completionItem returns the completion item of tag, without
+// documentation, which is filled in on resolve.
+func completionItem(tag ctags.Tag) lsp.CompletionItem {
+	kind, ok := nameToCompletionItemKind[tag.Kind]
+	if !ok {
+		kind = lsp.CIKText
+	}
+	detail := tag.Signature
+	if tag.Type != "" {
+		_, typ := splitTyperef(tag.Type)
+		detail = strings.TrimSpace(detail + " " + typ)
+	}
+	if detail == "" {
+		detail = tag.Kind
+	}
+	return lsp.CompletionItem{
+		Label:  tag.Name,
+		Kind:   kind,
+		Detail: detail,
+		Data:   tagRef{File: tag.File, Line: tag.Line, Name: tag.Name},
+	}
+}
+
+// resolveTagRef finds the tag ref refers to.
+func (s *LangSvc) resolveTagRef(ctx context.Context, data tagRef) (ctags.Tag, bool) {
+	tags, ok, _ := s.overlay.dirtyTags(ctx, data.File)
+	if !ok {
+		ix := s.index()
+		if ix == nil {
+			return ctags.Tag{}, false
+		}
+		tags = ix.FileTags(data.File)
+	}
+	for _, tag := range tags {
+		if tag.Name == data.Name && tag.Line == data.Line {
+			return tag, true
+		}
+	}
+	return ctags.Tag{}, false
+}
+
+type scoredCompletion struct {
+	ctags.Tag
+	score int
+}
+
+// byCompletionRank orders completions by score, then shorter names,
+// then name.
+type byCompletionRank []scoredCompletion
+
+func (s byCompletionRank) Len() int      { return len(s) }
+func (s byCompletionRank) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCompletionRank) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	if len(a.Name) != len(b.Name) {
+		return len(a.Name) < len(b.Name)
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	return a.Line < b.Line
+}
+
+// rankCompletions orders tags for completion and keeps the best tag of
+// each name and kind.
+func rankCompletions(tags []ctags.Tag, score func(ctags.Tag) int) []ctags.Tag {
+	scored := make(byCompletionRank, len(tags))
+	for i, tag := range tags {
+		scored[i] = scoredCompletion{tag, score(tag)}
+	}
+	sort.Sort(scored)
+
+	seen := make(map[string]bool)
+	ranked := make([]ctags.Tag, 0, len(scored))
+	for _, t := range scored {
+		key := t.Name + "\x00" + t.Kind
+		if !seen[key] {
+			seen[key] = true
+			ranked = append(ranked, t.Tag)
+		}
+	}
+	return ranked
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"strconv"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/jsonrpc2"
+)
+
+// conn is a session over a connection, such as stdio, a TCP connection
+// or a WebSocket.
+type conn struct {
+	*session
+	stream messageStream
+
+	wmu sync.Mutex // serializes writes to stream
+}
+
+// messageStream reads and writes whole JSON-RPC messages.
+type messageStream interface {
+	read() ([]byte, error)
+	write(msg []byte) error
+}
+
+// newConn returns a session over r and w. Parent is as for newSession.
+func newConn(r io.Reader, w io.Writer, h Handler, c Config, parent bool) *conn {
+	return &conn{
+		session: newSession(h, c, parent),
+		stream:  &byteStream{r: bufio.NewReader(r), w: w},
+	}
+}
+
+// serve handles messages until the client closes the connection or
+// sends exit, then cancels and waits for the requests still in flight.
+// It returns an error if the client exits without shutting the server
+// down first, or if its process dies while the session watches it.
+func (c *conn) serve() error {
+	defer c.close()
+
+	// Read on another goroutine, so that serve can return while a
+	// read is blocked. The caller closes the connection to end it.
+	msgs := make(chan []byte)
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := c.stream.read()
+			if err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-c.quit:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-msgs:
+			var req jsonrpc2.Request
+			if err := json.Unmarshal(msg, &req); err != nil {
+				c.send(errorWithoutID(codeParseError, err))
+				continue
+			}
+			c.handle(context.Background(), &req, c.reply)
+
+		case err := <-errc:
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+
+		case <-c.quit:
+			return c.exitErr
+		}
+	}
+}
+
+// byteStream carries messages over a byte stream.
+type byteStream struct {
+	r *bufio.Reader
+	w io.Writer
+
+	// framed is set if the client frames messages with LSP's
+	// Content-Length headers, and dec is used if it does not.
+	framed bool
+	dec    *json.Decoder
+}
+
+// maxMessageSize is the largest Content-Length that byteStream accepts,
+// so that a bad header cannot make it allocate without bound.
+const maxMessageSize = 64 << 20
+
+// read returns the next message. The framing of the first message
+// decides that of the rest: a message starting with '{' is taken to be
+// bare JSON, as written by plain JSON-RPC clients, and anything else
+// to be headers followed by a body of Content-Length bytes.
+func (c *byteStream) read() ([]byte, error) {
+	if c.dec == nil && !c.framed {
+		b, err := c.r.Peek(1)
+		for err == nil && (b[0] == ' ' || b[0] == '\t' || b[0] == '\r' || b[0] == '\n') {
+			c.r.ReadByte()
+			b, err = c.r.Peek(1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == '{' {
+			c.dec = json.NewDecoder(c.r)
+		} else {
+			c.framed = true
+		}
+	}
+	if c.dec != nil {
+		var msg json.RawMessage
+		err := c.dec.Decode(&msg)
+		return msg, err
+	}
+
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header %q", header.Get("Content-Length"))
+	}
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds the maximum of %d", n, maxMessageSize)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(c.r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// wireResponse is a response as written to the client. ID is nil, and
+// written as null, for errors about messages whose ID could not be
+// read.
+type wireResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *jsonrpc2.ID     `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpc2.Error  `json:"error,omitempty"`
+}
+
+func newWireResponse(resp *jsonrpc2.Response) wireResponse {
+	id := resp.ID
+	return wireResponse{JSONRPC: "2.0", ID: &id, Result: resp.Result, Error: resp.Error}
+}
+
+// errorWithoutID returns the error response to a message that could not
+// be read as a request.
+func errorWithoutID(code int64, err error) wireResponse {
+	return wireResponse{JSONRPC: "2.0", Error: &jsonrpc2.Error{Code: code, Message: err.Error()}}
+}
+
+// write writes msg, framed the way the client frames its messages.
+func (c *byteStream) write(msg []byte) error {
+	if c.framed {
+		_, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+		return err
+	}
+	_, err := fmt.Fprintf(c.w, "%s\n", msg)
+	return err
+}
+
+// reply writes resp to the client.
+func (c *conn) reply(resp *jsonrpc2.Response) {
+	c.send(newWireResponse(resp))
+}
+
+// send writes resp to the client.
+func (c *conn) send(resp wireResponse) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("! could not encode response: %s", err)
+		return
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.stream.write(b); err != nil {
+		log.Printf("! could not write response: %s", err)
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestByteStreamContentLength(t *testing.T) {
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{"Content-Length: 2\r\n\r\n{}", true},
+		{"Content-Length: -1\r\n\r\n{}", false},
+		{"Content-Length: x\r\n\r\n{}", false},
+		{"Content-Length: 1099511627776\r\n\r\n{}", false},
+	}
+	for _, test := range tests {
+		s := &byteStream{r: bufio.NewReader(strings.NewReader(test.input))}
+		msg, err := s.read()
+		if test.ok && (err != nil || string(msg) != "{}") {
+			t.Errorf("%q: got %q, %v, want {}", test.input, msg, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%q: got %q, want an error", test.input, msg)
+		}
+	}
+}
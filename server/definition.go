@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+)
+
+// importPrefixes start the lines that bring other packages or files
+// into scope, across the languages ctags supports.
+var importPrefixes = []string{"import ", "from ", "#include", "#import", "require", "use ", "using ", "include ", "load("}
+
+// rankDefinitions orders candidate definitions of a token appearing in
+// the document at docPath, with contents doc. Candidates are ranked by
+// same file, then same directory, then same language, then by whether
+// the token's qualifier or the document's imports point at them.
+func rankDefinitions(tags []ctags.Tag, docPath, doc, qualifier string, ix *index.Index) {
+	score := definitionScorer(docPath, doc, qualifier, ix)
+	scored := make(byDefinitionScore, len(tags))
+	for i, tag := range tags {
+		scored[i] = scoredTag{tag, score(tag)}
+	}
+	sort.Sort(scored)
+	for i := range scored {
+		tags[i] = scored[i].Tag
+	}
+}
+
+// definitionScorer returns the scoring function of rankDefinitions.
+func definitionScorer(docPath, doc, qualifier string, ix *index.Index) func(ctags.Tag) int {
+	docLang := ""
+	if ix != nil {
+		for _, tag := range ix.FileTags(docPath) {
+			if tag.Language != "" {
+				docLang = tag.Language
+				break
+			}
+		}
+	}
+	imports := importLines(doc)
+
+	return func(tag ctags.Tag) int {
+		s := 0
+		if tag.File == docPath {
+			s += 8
+		}
+		if filepath.Dir(tag.File) == filepath.Dir(docPath) {
+			s += 4
+		}
+		if docLang != "" && tag.Language == docLang || docLang == "" && filepath.Ext(tag.File) == filepath.Ext(docPath) {
+			s += 2
+		}
+		if hintsAt(tag, qualifier, imports) {
+			s++
+		}
+		return s
+	}
+}
+
+// hintsAt reports whether qualifier, the identifier the token was
+// qualified with (as in "pkg.Name" or "Class::name"), or one of the
+// document's import lines names tag's scope, file or directory.
+func hintsAt(tag ctags.Tag, qualifier string, imports []string) bool {
+	_, scope := index.SplitScope(tag.Scope, tag.Language)
+	names := []string{
+		filepath.Base(filepath.Dir(tag.File)),
+		strings.TrimSuffix(filepath.Base(tag.File), filepath.Ext(tag.File)),
+	}
+	if len(scope) > 0 {
+		names = append(names, scope[len(scope)-1])
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if name == qualifier {
+			return true
+		}
+		for _, imp := range imports {
+			if strings.Contains(imp, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func importLines(doc string) []string {
+	var imports []string
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(line)
+		for _, prefix := range importPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				imports = append(imports, line)
+				break
+			}
+		}
+	}
+	return imports
+}
+
+// qualifierBefore returns the identifier that qualifies the token
+// starting at character start of line, e.g. "pkg" in "pkg.Name" or
+// "Foo" in "Foo::bar" and "foo->bar".
+func qualifierBefore(line string, start int) string {
+	prefix := line[:start]
+	for _, sep := range []string{".", "::", "->"} {
+		if strings.HasSuffix(prefix, sep) {
+			prefix = strings.TrimSuffix(prefix, sep)
+			i := strings.LastIndexFunc(prefix, func(r rune) bool { return !isIdentRune(r) })
+			return prefix[i+1:]
+		}
+	}
+	return ""
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || r == '$' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r >= 0x80
+}
+
+// dirTags tags the files of dir and returns those named token. It is
+// the fallback used while the workspace index is not available.
+func dirTags(ctx context.Context, dir, token string) ([]ctags.Tag, error) {
+	dirfiles, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range dirfiles {
+		if !file.IsDir() {
+			files = append(files, filepath.Join(dir, file.Name()))
+		}
+	}
+	parser, err := ctags.ParseFilesContext(ctx, files)
+	if err != nil {
+		return nil, err
+	}
+	var matched []ctags.Tag
+	for _, tag := range parser.Tags() {
+		if tag.Name == token {
+			matched = append(matched, tag)
+		}
+	}
+	return matched, nil
+}
+
+type scoredTag struct {
+	ctags.Tag
+	score int
+}
+
+type byDefinitionScore []scoredTag
+
+func (s byDefinitionScore) Len() int      { return len(s) }
+func (s byDefinitionScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byDefinitionScore) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	return a.Line < b.Line
+}
+
+// withoutFile returns the tags not in file, reusing the storage of tags.
+func withoutFile(tags []ctags.Tag, file string) []ctags.Tag {
+	kept := tags[:0]
+	for _, tag := range tags {
+		if tag.File != file {
+			kept = append(kept, tag)
+		}
+	}
+	return kept
+}
+
+func tagsNamed(tags []ctags.Tag, name string) []ctags.Tag {
+	var named []ctags.Tag
+	for _, tag := range tags {
+		if tag.Name == name {
+			named = append(named, tag)
+		}
+	}
+	return named
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/jsonrpc2"
+)
+
+// JSON-RPC error codes, per
+// https://github.com/Microsoft/language-server-protocol/blob/master/protocol.md#response-message.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeInternalError  = -32603
+
+	// LSP specific codes.
+	codeServerNotInitialized = -32002
+	codeRequestCancelled     = -32800
+)
+
+// methods maps LSP method names to the LangSvc methods that implement
+// them. Each LangSvc method has the signature
+//
+//	func (s *LangSvc) M(ctx context.Context, params *P, result *R) error
+//
+// and dispatch decodes P from the request and encodes R as the
+// response.
+var methods = map[string]string{
+	"initialize":                        "Initialize",
+	"textDocument/didOpen":              "DidOpen",
+	"textDocument/didChange":            "DidChange",
+	"textDocument/didClose":             "DidClose",
+	"textDocument/didSave":              "DidSave",
+	"textDocument/completion":           "Completion",
+	"completionItem/resolve":            "CompletionItemResolve",
+	"textDocument/hover":                "Hover",
+	"textDocument/signatureHelp":        "SignatureHelpRequest",
+	"textDocument/definition":           "GoToDefinition",
+	"textDocument/references":           "References",
+	"textDocument/typeDefinition":       "TypeDefinition",
+	"textDocument/implementation":       "Implementation",
+	"textDocument/prepareTypeHierarchy": "PrepareTypeHierarchy",
+	"typeHierarchy/supertypes":          "TypeHierarchySupertypes",
+	"typeHierarchy/subtypes":            "TypeHierarchySubtypes",
+	"textDocument/documentHighlight":    "DocumentHighlights",
+	"textDocument/documentSymbol":       "DocumentSymbols",
+	"textDocument/foldingRange":         "FoldingRanges",
+	"workspace/symbol":                  "WorkspaceSymbols",
+	"textDocument/codeAction":           "CodeAction",
+	"textDocument/codeLens":             "CodeLensRequest",
+	"codeLens/resolve":                  "CodeLensResolve",
+	"textDocument/formatting":           "DocumentFormatting",
+	"textDocument/onTypeFormatting":     "DocumentOnTypeFormatting",
+	"textDocument/rename":               "Rename",
+	"textDocument/prepareRename":        "PrepareRename",
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// dispatch calls the method of svc implementing req.Method and returns
+// its result. Errors are returned as *jsonrpc2.Error, carrying the
+// JSON-RPC code to respond with.
+func dispatch(ctx context.Context, svc *LangSvc, req *jsonrpc2.Request) (interface{}, *jsonrpc2.Error) {
+	name, ok := methods[req.Method]
+	if !ok {
+		return nil, &jsonrpc2.Error{Code: codeMethodNotFound, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+	m := reflect.ValueOf(svc).MethodByName(name)
+	if !m.IsValid() {
+		return nil, &jsonrpc2.Error{Code: codeMethodNotFound, Message: fmt.Sprintf("method not implemented: %s", req.Method)}
+	}
+	mt := m.Type()
+	if mt.NumIn() != 3 || mt.In(0) != contextType || mt.In(1).Kind() != reflect.Ptr || mt.In(2).Kind() != reflect.Ptr || mt.NumOut() != 1 || mt.Out(0) != errorType {
+		panic(fmt.Sprintf("LangSvc.%s does not have the signature func(ctx context.Context, params *P, result *R) error", name))
+	}
+
+	params := reflect.New(mt.In(1).Elem())
+	if req.Params != nil {
+		raw, err := namedParams(*req.Params)
+		if err != nil {
+			return nil, &jsonrpc2.Error{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params for %s: %s", req.Method, err)}
+		}
+		if err := json.Unmarshal(raw, params.Interface()); err != nil {
+			return nil, &jsonrpc2.Error{Code: codeInvalidParams, Message: fmt.Sprintf("invalid params for %s: %s", req.Method, err)}
+		}
+	}
+	result := reflect.New(mt.In(2).Elem())
+	err := m.Call([]reflect.Value{reflect.ValueOf(ctx), params, result})[0].Interface()
+	if ctx.Err() != nil {
+		// Whatever the method made of it, it was cut short.
+		return nil, &jsonrpc2.Error{Code: codeRequestCancelled, Message: fmt.Sprintf("%s: %s", req.Method, ctx.Err())}
+	}
+	if err != nil {
+		return nil, &jsonrpc2.Error{Code: codeInternalError, Message: err.(error).Error()}
+	}
+	return result.Elem().Interface(), nil
+}
+
+// namedParams returns the params object of a request. Clients that only
+// send positional params, like the jayson client in client/, wrap it in
+// an array, so the first element of an array is taken to be the object.
+func namedParams(raw json.RawMessage) (json.RawMessage, error) {
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) == 0 || trimmed[0] != '[' {
+		return raw, nil
+	}
+	var positional []json.RawMessage
+	if err := json.Unmarshal(raw, &positional); err != nil {
+		return nil, err
+	}
+	switch len(positional) {
+	case 0:
+		return json.RawMessage("null"), nil
+	case 1:
+		return positional[0], nil
+	}
+	return nil, fmt.Errorf("expected at most 1 positional param, got %d", len(positional))
+}
+
+// decodeData decodes into v the data field of a protocol object, such
+// as a completion item, which the client hands back as generic JSON.
+func decodeData(data interface{}, v interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid data: %s", err)
+	}
+	return nil
+}
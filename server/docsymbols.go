@@ -0,0 +1,88 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sourcegraph/tag-server/index"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// documentSymbols returns the symbols of a document nested by scope.
+// Scopes without a tag of their own in the document, such as a class
+// whose methods are defined here but which is declared elsewhere, are
+// left out and their members take their place, as are symbols whose
+// line is past the end of the document.
+func documentSymbols(tree *index.Tree, lines []string) []documentSymbol {
+	var build func(nodes []*index.Node) []documentSymbol
+	build = func(nodes []*index.Node) []documentSymbol {
+		syms := []documentSymbol{}
+		for _, n := range nodes {
+			if !n.Synthetic {
+				if sym, ok := nodeSymbol(n, lines); ok {
+					sym.Children = build(n.Children)
+					syms = append(syms, sym)
+					continue
+				}
+			}
+			syms = append(syms, build(n.Children)...)
+		}
+		sort.Stable(symbolsByPosition(syms))
+		return syms
+	}
+	return build(tree.Roots)
+}
+
+// nodeSymbol returns the document symbol of n, without children. It
+// returns false if n's line is not in the document. If n's name cannot
+// be found on its line, as when the file changed since it was tagged,
+// the symbol selects the whole line.
+func nodeSymbol(n *index.Node, lines []string) (documentSymbol, bool) {
+	tag := n.Tag
+	if tag.Line < 1 || tag.Line > len(lines) {
+		return documentSymbol{}, false
+	}
+	line := strings.TrimRight(lines[tag.Line-1], "\r")
+
+	end := n.End
+	if end < tag.Line {
+		end = tag.Line
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	start := len(line) - len(strings.TrimLeft(line, " \t"))
+	sel := lineRange(tag.Line-1, line, 0, len(line))
+	if nameIdx := strings.Index(line, tag.Name); nameIdx >= 0 {
+		if start > nameIdx {
+			start = nameIdx
+		}
+		sel = lineRange(tag.Line-1, line, nameIdx, nameIdx+len(tag.Name))
+	} else {
+		start = 0
+	}
+	detail := tag.Signature
+	if tag.Type != "" {
+		_, typ := splitTyperef(tag.Type)
+		detail = strings.TrimSpace(detail + " " + typ)
+	}
+	return documentSymbol{
+		Name:   tag.Name,
+		Detail: detail,
+		Kind:   symbolKind(tag.Kind),
+		Range: lsp.Range{
+			Start: lsp.Position{Line: tag.Line - 1, Character: utf16Len(line[:start])},
+			End:   lsp.Position{Line: end - 1, Character: utf16Len(strings.TrimRight(lines[end-1], "\r"))},
+		},
+		SelectionRange: sel,
+	}, true
+}
+
+type symbolsByPosition []documentSymbol
+
+func (s symbolsByPosition) Len() int      { return len(s) }
+func (s symbolsByPosition) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s symbolsByPosition) Less(i, j int) bool {
+	return s[i].Range.Start.Line < s[j].Range.Start.Line
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+func TestDocumentSymbolsNameNotOnLine(t *testing.T) {
+	// The file was edited since it was tagged: the class was renamed,
+	// but its method is still where the tags say.
+	lines := strings.Split("class Renamed:\n    def run(self):\n        pass\n", "\n")
+	tree := index.FileTree([]ctags.Tag{
+		{Name: "Old", Kind: "class", File: "a.py", Language: "Python", Line: 1, End: 3},
+		{Name: "run", Kind: "member", Scope: "class:Old", File: "a.py", Language: "Python", Line: 2, End: 3},
+		{Name: "gone", Kind: "function", Scope: "class:Old", File: "a.py", Language: "Python", Line: 40},
+	})
+
+	syms := documentSymbols(tree, lines)
+	if len(syms) != 1 || syms[0].Name != "Old" {
+		t.Fatalf("got top-level symbols %+v, want Old", syms)
+	}
+	old := syms[0]
+	if want := (lsp.Range{Start: lsp.Position{Line: 0}, End: lsp.Position{Line: 0, Character: 14}}); old.SelectionRange != want {
+		t.Errorf("Old: selection %+v, want the whole line %+v", old.SelectionRange, want)
+	}
+	if len(old.Children) != 1 || old.Children[0].Name != "run" {
+		t.Fatalf("Old: children %+v, want run", old.Children)
+	}
+	if want := (lsp.Range{Start: lsp.Position{Line: 1, Character: 8}, End: lsp.Position{Line: 1, Character: 11}}); old.Children[0].SelectionRange != want {
+		t.Errorf("run: selection %+v, want %+v", old.Children[0].SelectionRange, want)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+)
+
+// Folding range kinds.
+const (
+	foldComment = "comment"
+	foldImports = "imports"
+)
+
+// foldingRanges returns the folding ranges of a document with the given
+// lines and tags in language lang: one per multi-line definition, and
+// one per block of comments or of imports.
+func foldingRanges(lines []string, tags []ctags.Tag, lang string) []foldingRange {
+	ranges := definitionFolds(index.FileTree(tags), lines)
+	ranges = append(ranges, commentFolds(lines, syntaxOf(lang))...)
+	ranges = append(ranges, importFolds(lines)...)
+	sort.Stable(foldsByStart(ranges))
+	return ranges
+}
+
+// definitionFolds returns a range for each definition in tree that
+// spans several lines, per the end lines computed for the tree. They
+// have no kind: "region" is for explicit region markers, which clients
+// may fold differently.
+func definitionFolds(tree *index.Tree, lines []string) []foldingRange {
+	var ranges []foldingRange
+	tree.Walk(func(n *index.Node) bool {
+		if n.Synthetic {
+			return true
+		}
+		start, end := n.Tag.Line-1, n.End-1
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+		// Inferred ends run up to the next definition; leave out the
+		// blank lines before it.
+		for end > start && strings.TrimSpace(lines[end]) == "" {
+			end--
+		}
+		if start >= 0 && end > start {
+			ranges = append(ranges, foldingRange{StartLine: start, EndLine: end})
+		}
+		return true
+	})
+	return ranges
+}
+
+// commentFolds returns a range for each block comment and each run of
+// line comments that span several lines.
+func commentFolds(lines []string, syn syntax) []foldingRange {
+	var ranges []foldingRange
+	isLineComment := func(line string) bool {
+		for _, m := range syn.lineComments {
+			if strings.HasPrefix(line, m) {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		start := i
+		switch {
+		case syn.blockComment != nil && strings.HasPrefix(line, syn.blockComment[0]):
+			open, close := syn.blockComment[0], syn.blockComment[1]
+			if strings.Contains(line[len(open):], close) {
+				continue
+			}
+			for i+1 < len(lines) {
+				i++
+				if strings.Contains(lines[i], close) {
+					break
+				}
+			}
+		case isLineComment(line):
+			for i+1 < len(lines) && isLineComment(strings.TrimSpace(lines[i+1])) {
+				i++
+			}
+		default:
+			continue
+		}
+		if i > start {
+			ranges = append(ranges, foldingRange{StartLine: start, EndLine: i, Kind: foldComment})
+		}
+	}
+	return ranges
+}
+
+// importFolds returns a range for each parenthesized import group, as
+// in Go, and each run of import lines.
+func importFolds(lines []string) []foldingRange {
+	var ranges []foldingRange
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if !isImportLine(line) {
+			continue
+		}
+		start := i
+		if strings.HasSuffix(line, "(") {
+			for i+1 < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), ")") {
+				i++
+			}
+		} else {
+			// Blank lines may separate groups of imports.
+			for j := i + 1; j < len(lines); j++ {
+				next := strings.TrimSpace(lines[j])
+				if isImportLine(next) {
+					i = j
+				} else if next != "" {
+					break
+				}
+			}
+		}
+		if i > start {
+			ranges = append(ranges, foldingRange{StartLine: start, EndLine: i, Kind: foldImports})
+		}
+	}
+	return ranges
+}
+
+func isImportLine(line string) bool {
+	for _, prefix := range importPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type foldsByStart []foldingRange
+
+func (f foldsByStart) Len() int           { return len(f) }
+func (f foldsByStart) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f foldsByStart) Less(i, j int) bool { return f[i].StartLine < f[j].StartLine }
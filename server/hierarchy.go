@@ -0,0 +1,180 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// typeKinds are the tag kinds that other types can extend or
+// implement.
+var typeKinds = map[string]bool{
+	"class":     true,
+	"interface": true,
+	"struct":    true,
+	"trait":     true,
+	"protocol":  true,
+}
+
+// inheritedNames returns the simple names of the types tag extends or
+// implements, per its inherits field: "Base" for each of "Base",
+// "pkg.Base", "ns::Base" and "Base<T>".
+func inheritedNames(tag ctags.Tag) []string {
+	if tag.Inheritance == "" {
+		return nil
+	}
+	var names []string
+	for _, base := range splitParams(tag.Inheritance) {
+		names = append(names, simpleTypeName(base, tag.Language))
+	}
+	return names
+}
+
+// simpleTypeName strips a type name of access specifiers, type
+// arguments and qualifiers.
+func simpleTypeName(typ, lang string) string {
+	typ = strings.TrimSpace(typ)
+	if i := strings.IndexAny(typ, "<[("); i >= 0 {
+		typ = typ[:i]
+	}
+	if fields := strings.Fields(typ); len(fields) > 0 {
+		// "public Base", "virtual Base"
+		typ = fields[len(fields)-1]
+	}
+	if _, path := index.SplitScope(typ, lang); len(path) > 0 {
+		typ = path[len(path)-1]
+	}
+	return typ
+}
+
+// inheritanceGraph links the types of the workspace to the types they
+// extend or implement, per the inherits fields of their tags. Edges are
+// by simple name, as ctags records them.
+type inheritanceGraph struct {
+	ix  *index.Index
+	gen uint64 // generation of ix the graph was built from
+
+	// subs maps a type name to the tags of the types inheriting it.
+	subs map[string][]ctags.Tag
+}
+
+func newInheritanceGraph(ix *index.Index) *inheritanceGraph {
+	g := &inheritanceGraph{ix: ix, gen: ix.Generation(), subs: make(map[string][]ctags.Tag)}
+	for _, file := range ix.Files() {
+		for _, tag := range ix.FileTags(file) {
+			for _, base := range inheritedNames(tag) {
+				g.subs[base] = append(g.subs[base], tag)
+			}
+		}
+	}
+	return g
+}
+
+// subtypes returns the types that directly extend or implement a type
+// named name.
+func (g *inheritanceGraph) subtypes(name string) []ctags.Tag {
+	return g.subs[name]
+}
+
+// allSubtypes returns the types that extend or implement a type named
+// name, directly or not, nearest first.
+func (g *inheritanceGraph) allSubtypes(name string) []ctags.Tag {
+	var all []ctags.Tag
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+	for len(queue) > 0 {
+		for _, sub := range g.subs[queue[0]] {
+			key := sub.File + ":" + sub.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			all = append(all, sub)
+			if !seen[sub.Name] {
+				seen[sub.Name] = true
+				queue = append(queue, sub.Name)
+			}
+		}
+		queue = queue[1:]
+	}
+	return all
+}
+
+// supertypes returns the types tag directly extends or implements that
+// are defined in the workspace. Of several types of the same name, the
+// one nearest to tag is picked.
+func (g *inheritanceGraph) supertypes(tag ctags.Tag) []ctags.Tag {
+	var supers []ctags.Tag
+	for _, base := range inheritedNames(tag) {
+		var candidates []ctags.Tag
+		for _, t := range g.ix.Lookup(base) {
+			if typeKinds[t.Kind] {
+				candidates = append(candidates, t)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		rankDefinitions(candidates, tag.File, "", "", g.ix)
+		supers = append(supers, candidates[0])
+	}
+	return supers
+}
+
+// implementations returns the implementations of the symbols defined
+// by defs: the subtypes of types, and for methods, the methods of the
+// same name in the subtypes of their class.
+func (g *inheritanceGraph) implementations(defs []ctags.Tag) []ctags.Tag {
+	var impls []ctags.Tag
+	for _, def := range defs {
+		if typeKinds[def.Kind] {
+			impls = append(impls, g.allSubtypes(def.Name)...)
+			continue
+		}
+		_, scope := index.SplitScope(def.Scope, def.Language)
+		if len(scope) == 0 {
+			continue
+		}
+		subs := make(map[string]bool)
+		for _, sub := range g.allSubtypes(scope[len(scope)-1]) {
+			subs[sub.Name] = true
+		}
+		for _, t := range g.ix.Lookup(def.Name) {
+			if _, path := index.SplitScope(t.Scope, t.Language); len(path) > 0 && subs[path[len(path)-1]] {
+				impls = append(impls, t)
+			}
+		}
+	}
+	return impls
+}
+
+// tagHierarchyItem returns the type hierarchy item of tag.
+func tagHierarchyItem(tag ctags.Tag) typeHierarchyItem {
+	end := tag.End
+	if end < tag.Line {
+		end = tag.Line
+	}
+	return typeHierarchyItem{
+		Name:   tag.Name,
+		Kind:   symbolKind(tag.Kind),
+		Detail: tag.Scope,
+		URI:    "file://" + tag.File,
+		Range: lsp.Range{
+			Start: lsp.Position{Line: tag.Line - 1},
+			End:   lsp.Position{Line: end},
+		},
+		SelectionRange: nameRange(tag.Line-1, tag.DefLinePrefix, tag.Name),
+		Data:           tagRef{File: tag.File, Line: tag.Line, Name: tag.Name},
+	}
+}
+
+func typeHierarchyItems(tags []ctags.Tag) []typeHierarchyItem {
+	items := make([]typeHierarchyItem, len(tags))
+	for i, tag := range tags {
+		items[i] = tagHierarchyItem(tag)
+	}
+	return items
+}
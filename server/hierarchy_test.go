@@ -0,0 +1,31 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+func TestInheritanceGraphCache(t *testing.T) {
+	s := indexedLangSvc("/src", []ctags.Tag{
+		{Name: "Base", Kind: "class", File: "/src/base.py", Language: "Python", Line: 1},
+		{Name: "Derived", Kind: "class", File: "/src/derived.py", Language: "Python", Line: 1, Inheritance: "Base"},
+	})
+
+	g := s.workspace.inheritanceGraph()
+	if subs := g.subtypes("Base"); len(subs) != 1 || subs[0].Name != "Derived" {
+		t.Fatalf("subtypes of Base = %v, want Derived", subs)
+	}
+	if s.workspace.inheritanceGraph() != g {
+		t.Error("graph rebuilt although the index did not change")
+	}
+
+	s.workspace.index.Replace("/src/derived.py", nil)
+	g2 := s.workspace.inheritanceGraph()
+	if g2 == g {
+		t.Fatal("graph not rebuilt after the index changed")
+	}
+	if subs := g2.subtypes("Base"); len(subs) != 0 {
+		t.Errorf("after removing Derived, subtypes of Base = %v", subs)
+	}
+}
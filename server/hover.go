@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+)
+
+// languageIDs maps ctags language names to the identifiers LSP
+// clients and markdown renderers use for them, where the two differ by
+// more than case.
+var languageIDs = map[string]string{
+	"C++":        "cpp",
+	"C#":         "csharp",
+	"ObjectiveC": "objective-c",
+	"Sh":         "shell",
+	"DosBatch":   "bat",
+	"Vim":        "viml",
+	"Tex":        "latex",
+	"MatLab":     "matlab",
+}
+
+func languageID(lang string) string {
+	if id, ok := languageIDs[lang]; ok {
+		return id
+	}
+	return strings.ToLower(lang)
+}
+
+// lineComments lists the line comment markers of each language, longest
+// first. Languages not listed use defaultLineComments.
+var lineComments = map[string][]string{
+	"C":          {"//"},
+	"C++":        {"///", "//"},
+	"C#":         {"///", "//"},
+	"Go":         {"//"},
+	"Java":       {"//"},
+	"JavaScript": {"//"},
+	"ObjectiveC": {"//"},
+	"Rust":       {"///", "//!", "//"},
+	"PHP":        {"//", "#"},
+	"Python":     {"#"},
+	"Ruby":       {"#"},
+	"Perl":       {"#"},
+	"Sh":         {"#"},
+	"Make":       {"#"},
+	"R":          {"#"},
+	"Tcl":        {"#"},
+	"Awk":        {"#"},
+	"CMake":      {"#"},
+	"Elixir":     {"#"},
+	"PowerShell": {"#"},
+	"Lua":        {"--"},
+	"SQL":        {"--"},
+	"Ada":        {"--"},
+	"Lisp":       {";;", ";"},
+	"Scheme":     {";;", ";"},
+	"Clojure":    {";;", ";"},
+	"Erlang":     {"%%", "%"},
+	"Tex":        {"%"},
+	"MatLab":     {"%"},
+	"Vim":        {`"`},
+	"Fortran":    {"!"},
+}
+
+// defaultLineComments is "//" alone: treating "#" as a comment would
+// hide TypeScript #private fields, C# #region lines and the like.
+var defaultLineComments = []string{"//"}
+
+// hoverMarkdown renders a tag for display in a hover: its definition
+// line in a fenced code block, a summary of its kind, signature, scope
+// and location, and its doc comment if it has one.
+func (s *LangSvc) hoverMarkdown(tag ctags.Tag) string {
+	var lines []string
+	if contents, err := s.fetchFile("file://" + tag.File); err == nil {
+		lines = strings.Split(contents, "\n")
+	}
+	def := tag.DefLinePrefix
+	if tag.Line > 0 && tag.Line <= len(lines) {
+		def = lines[tag.Line-1]
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "```%s\n%s\n```\n\n", languageID(tag.Language), strings.TrimSpace(def))
+
+	var summary []string
+	if tag.Kind != "" {
+		summary = append(summary, "*"+tag.Kind+"*")
+	}
+	summary = append(summary, "`"+tag.Name+tag.Signature+"`")
+	if tag.Type != "" {
+		_, typ := splitTyperef(tag.Type)
+		summary = append(summary, "of type `"+typ+"`")
+	}
+	if kind, path := index.SplitScope(tag.Scope, tag.Language); len(path) > 0 {
+		scope := "in"
+		if kind != "" {
+			scope += " " + kind
+		}
+		summary = append(summary, fmt.Sprintf("%s `%s`", scope, strings.TrimPrefix(tag.Scope, kind+":")))
+	}
+	summary = append(summary, fmt.Sprintf("— `%s:%d`", s.displayPath(tag.File), tag.Line))
+	b.WriteString(strings.Join(summary, " "))
+
+	if doc := docComment(lines, tag.Line, tag.Language); doc != "" {
+		b.WriteString("\n\n---\n\n")
+		b.WriteString(doc)
+	}
+	return b.String()
+}
+
+// displayPath returns path relative to the workspace root, when it is
+// inside it.
+func (s *LangSvc) displayPath(path string) string {
+	if s.RootPath != "" {
+		if rel, err := filepath.Rel(s.RootPath, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// splitTyperef splits a ctags typeref field such as "typename:int" or
+// "struct:foo" into its kind and type name.
+func splitTyperef(typeref string) (kind, name string) {
+	if i := strings.Index(typeref, ":"); i >= 0 {
+		return typeref[:i], typeref[i+1:]
+	}
+	return "", typeref
+}
+
+// maxDocLines bounds the length of an extracted doc comment.
+const maxDocLines = 30
+
+// docComment extracts the documentation of the definition on line
+// (1-based) of a file: the comment block immediately above it, or for
+// Python a docstring immediately below it.
+func docComment(lines []string, line int, lang string) string {
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	if lang == "Python" {
+		if doc := docstring(lines[line:]); doc != "" {
+			return doc
+		}
+	}
+
+	var doc []string
+	i := line - 2
+	if i >= 0 && strings.HasSuffix(strings.TrimSpace(lines[i]), "*/") {
+		// Block comment: collect up to the line that opens it.
+		for ; i >= 0 && len(doc) < maxDocLines; i-- {
+			l := strings.TrimSpace(lines[i])
+			doc = append(doc, l)
+			if strings.HasPrefix(l, "/*") {
+				break
+			}
+		}
+		reverse(doc)
+		for j, l := range doc {
+			l = strings.TrimSuffix(l, "*/")
+			l = strings.TrimLeft(l, "/*!")
+			doc[j] = strings.TrimSpace(l)
+		}
+		return strings.TrimSpace(strings.Join(doc, "\n"))
+	}
+
+	markers, ok := lineComments[lang]
+	if !ok {
+		markers = defaultLineComments
+	}
+outer:
+	for ; i >= 0 && len(doc) < maxDocLines; i-- {
+		l := strings.TrimSpace(lines[i])
+		for _, m := range markers {
+			if strings.HasPrefix(l, m) {
+				doc = append(doc, strings.TrimSpace(strings.TrimPrefix(l, m)))
+				continue outer
+			}
+		}
+		break
+	}
+	reverse(doc)
+	return strings.TrimSpace(strings.Join(doc, "\n"))
+}
+
+// docstring returns the Python docstring opening the body that starts
+// at lines[0], if any.
+func docstring(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	first := strings.TrimSpace(lines[0])
+	var quote string
+	for _, q := range []string{`"""`, `'''`} {
+		if strings.HasPrefix(first, q) {
+			quote = q
+		}
+	}
+	if quote == "" {
+		return ""
+	}
+	first = strings.TrimPrefix(first, quote)
+	if i := strings.Index(first, quote); i >= 0 {
+		return strings.TrimSpace(first[:i])
+	}
+	doc := []string{first}
+	for _, l := range lines[1:] {
+		if len(doc) >= maxDocLines {
+			break
+		}
+		l = strings.TrimSpace(l)
+		if i := strings.Index(l, quote); i >= 0 {
+			doc = append(doc, l[:i])
+			break
+		}
+		doc = append(doc, l)
+	}
+	return strings.TrimSpace(strings.Join(doc, "\n"))
+}
+
+func reverse(s []string) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/jsonrpc2"
+)
+
+const (
+	// sessionHeader and sessionCookie carry the ID of an HTTP
+	// session. Responses set both; requests may use either, with the
+	// header taking precedence.
+	sessionHeader = "X-Session-Id"
+	sessionCookie = "tag_server_session"
+
+	// sessionIdleTimeout ends HTTP sessions that have not been used
+	// for this long, since HTTP clients cannot be relied on to exit.
+	sessionIdleTimeout = 30 * time.Minute
+
+	// maxRequestBody caps the size of a POSTed message or batch.
+	maxRequestBody = 32 << 20
+)
+
+// httpServer serves JSON-RPC over HTTP. Each POST body holds a request,
+// notification or batch, and the response body holds the responses in
+// the same shape. Requests with the same session ID share a session,
+// as the messages of a stream connection do. Browsers are held to the
+// same origins as WebSockets.
+type httpServer struct {
+	c Config
+
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+type httpSession struct {
+	*session
+	svc      *LangSvc
+	lastUsed time.Time // guarded by httpServer.mu
+}
+
+func newHTTPServer(c Config) *httpServer {
+	h := &httpServer{c: c, sessions: make(map[string]*httpSession)}
+	go h.reapIdle()
+	return h
+}
+
+func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !originAllowed(h.c, r) {
+		log.Printf("! refused POST from origin %q", r.Header.Get("Origin"))
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, sess := h.session(r)
+	w.Header().Set(sessionHeader, id)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", HttpOnly: true})
+
+	var (
+		mu    sync.Mutex
+		resps []wireResponse
+		wg    sync.WaitGroup
+	)
+	reply := func(resp *jsonrpc2.Response) {
+		mu.Lock()
+		resps = append(resps, newWireResponse(resp))
+		mu.Unlock()
+		wg.Done()
+	}
+	msgs, batch, err := splitBatch(body)
+	if err != nil {
+		resps = append(resps, errorWithoutID(codeInvalidRequest, err))
+	}
+	for _, msg := range msgs {
+		var req jsonrpc2.Request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			mu.Lock()
+			resps = append(resps, errorWithoutID(codeParseError, err))
+			mu.Unlock()
+			continue
+		}
+		if !req.Notification {
+			wg.Add(1)
+		}
+		sess.handle(r.Context(), &req, reply)
+	}
+	wg.Wait()
+
+	select {
+	case <-sess.quit:
+		h.end(id, sess)
+	default:
+	}
+
+	if len(resps) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var out interface{} = resps
+	if !batch {
+		out = resps[0]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Printf("! could not write response: %s", err)
+	}
+}
+
+// splitBatch returns the messages in body, and whether they were sent
+// as a batch.
+func splitBatch(body []byte) (msgs []json.RawMessage, batch bool, err error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || body[0] != '[' {
+		return []json.RawMessage{body}, false, nil
+	}
+	if err := json.Unmarshal(body, &msgs); err != nil {
+		return nil, false, err
+	}
+	if len(msgs) == 0 {
+		return nil, false, errors.New("empty batch")
+	}
+	return msgs, true, nil
+}
+
+// session returns the session named by r, starting a new one if r
+// names none or one that has ended.
+func (h *httpServer) session(r *http.Request) (string, *httpSession) {
+	id := r.Header.Get(sessionHeader)
+	if id == "" {
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			id = c.Value
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sess, ok := h.sessions[id]
+	if !ok {
+		if id != "" {
+			log.Printf("unknown session %q, starting a new one", id)
+		}
+		id = newSessionID()
+		svc := newLangSvc(h.c)
+		sess = &httpSession{session: newSession(Handler{svc}, h.c, false), svc: svc}
+		h.sessions[id] = sess
+		log.Printf("session %s opened for %s", id, r.RemoteAddr)
+	}
+	sess.lastUsed = time.Now()
+	return id, sess
+}
+
+// end forgets the session and releases its state.
+func (h *httpServer) end(id string, sess *httpSession) {
+	h.mu.Lock()
+	if h.sessions[id] != sess {
+		h.mu.Unlock()
+		return // already ended
+	}
+	delete(h.sessions, id)
+	h.mu.Unlock()
+
+	sess.close()
+	sess.svc.close()
+	log.Printf("session %s closed", id)
+}
+
+// reapIdle ends the sessions that have gone unused for too long, or
+// that have stopped without being ended.
+func (h *httpServer) reapIdle() {
+	for range time.Tick(time.Minute) {
+		h.mu.Lock()
+		ended := make(map[string]*httpSession)
+		for id, sess := range h.sessions {
+			select {
+			case <-sess.quit:
+				ended[id] = sess
+				continue
+			default:
+			}
+			if time.Since(sess.lastUsed) > sessionIdleTimeout {
+				ended[id] = sess
+			}
+		}
+		h.mu.Unlock()
+
+		for id, sess := range ended {
+			h.end(id, sess)
+		}
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// post POSTs body to h in the given session, a new one if session is
+// empty, failing if h does not answer promptly. It returns the status,
+// the decoded response body and the session ID.
+func post(t *testing.T, h http.Handler, session, body string) (int, map[string]interface{}, string) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	if session != "" {
+		req.Header.Set(sessionHeader, session)
+	}
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(w, req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("POST %s: no response", body)
+	}
+
+	var resp map[string]interface{}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("POST %s: %s", body, err)
+		}
+	}
+	return w.Code, resp, w.Header().Get(sessionHeader)
+}
+
+func TestHTTPRepliesOnceToEachRequest(t *testing.T) {
+	h := newHTTPServer(Config{})
+	var session string
+
+	tests := []struct {
+		body     string
+		wantCode int
+		wantID   interface{} // of the response, if wantCode is 200
+		wantErr  bool
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`, http.StatusOK, 1.0, false},
+		{`{"jsonrpc":"2.0","method":"initialized","params":{}}`, http.StatusNoContent, nil, false},
+		{`{"jsonrpc":"2.0","method":"initialize","params":{}}`, http.StatusNoContent, nil, false},
+		{`{"jsonrpc":"2.0","id":2,"method":"initialize","params":{}}`, http.StatusOK, 2.0, true},
+		{`{"jsonrpc":"2.0","id":3,"method":"$/cancelRequest","params":{"id":99}}`, http.StatusOK, 3.0, false},
+		{`{"jsonrpc":"2.0","method":"$/cancelRequest","params":{"id":99}}`, http.StatusNoContent, nil, false},
+		{`{bad`, http.StatusOK, nil, true},
+		{`{"jsonrpc":"2.0","id":"s","method":"shutdown"}`, http.StatusOK, "s", false},
+		{`{"jsonrpc":"2.0","id":4,"method":"exit"}`, http.StatusOK, 4.0, false},
+	}
+	for _, test := range tests {
+		code, resp, id := post(t, h, session, test.body)
+		session = id
+		if code != test.wantCode {
+			t.Errorf("POST %s: status %d, want %d", test.body, code, test.wantCode)
+			continue
+		}
+		if code != http.StatusOK {
+			continue
+		}
+		if id, ok := resp["id"]; !ok || id != test.wantID {
+			t.Errorf("POST %s: id %v, want %v", test.body, resp["id"], test.wantID)
+		}
+		if _, isErr := resp["error"]; isErr != test.wantErr {
+			t.Errorf("POST %s: got %v, want error %v", test.body, resp, test.wantErr)
+		}
+		if _, hasResult := resp["result"]; !test.wantErr && !hasResult {
+			t.Errorf("POST %s: got %v, want a result", test.body, resp)
+		}
+	}
+
+	h.mu.Lock()
+	_, open := h.sessions[session]
+	h.mu.Unlock()
+	if open {
+		t.Error("session still open after exit")
+	}
+}
+
+func TestHTTPOrigin(t *testing.T) {
+	h := newHTTPServer(Config{AllowedOrigins: []string{"https://viewer.example.com"}})
+	tests := []struct {
+		origin   string
+		wantCode int
+	}{
+		{"", http.StatusOK},
+		{"http://example.com", http.StatusOK}, // the server's own host
+		{"https://viewer.example.com", http.StatusOK},
+		{"https://evil.example.org", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "http://example.com/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"shutdown"}`))
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != test.wantCode {
+			t.Errorf("Origin %q: status %d, want %d", test.origin, w.Code, test.wantCode)
+		}
+	}
+}
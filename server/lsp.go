@@ -1,87 +1,300 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
 
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
 )
 
+// LangSvc is the state of one client session: its root, the
+// documents it has open and what it can handle. Sessions on the same
+// root share the workspace index.
 type LangSvc struct {
 	RootPath string
+
+	// workspace indexes RootPath. It is nil until initialize, and is
+	// released by close.
+	workspace *workspace
+
+	// overlay holds the documents open in the client.
+	overlay *overlay
+
+	// hierarchicalSymbols is set if the client accepts nested
+	// document symbols.
+	hierarchicalSymbols bool
+
+	// SkipCommentsAndStrings leaves occurrences in comments and string
+	// literals out of references.
+	SkipCommentsAndStrings bool
+
+	// retagCtx is cancelled by close, stopping the re-tagging of saved
+	// files, which runs in the background and is tracked by retags.
+	retagCtx    context.Context
+	retagCancel context.CancelFunc
+	retags      sync.WaitGroup
+}
+
+// indexWait bounds how long a request waits for the workspace index
+// before falling back to tagging the document's directory.
+const indexWait = 2 * time.Second
+
+func newLangSvc(c Config) *LangSvc {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LangSvc{
+		overlay:                newOverlay(),
+		SkipCommentsAndStrings: !c.IncludeCommentsAndStrings,
+		retagCtx:               ctx,
+		retagCancel:            cancel,
+	}
+}
+
+// close stops re-tagging saved files, waits for it to finish and
+// releases the session's workspace. The session must not be used
+// afterwards.
+func (s *LangSvc) close() {
+	s.retagCancel()
+	s.retags.Wait()
+	s.releaseWorkspace()
 }
 
-var Server = &LangSvc{}
+// releaseWorkspace releases the session's workspace, if it has one.
+func (s *LangSvc) releaseWorkspace() {
+	if s.workspace != nil {
+		s.workspace.release()
+		s.workspace = nil
+	}
+}
 
-func (s *LangSvc) Initialize(params *lsp.InitializeParams, result *lsp.InitializeResult) error {
+func (s *LangSvc) Initialize(ctx context.Context, params *initializeParams, result *initializeResult) error {
 	log.Printf("LangSvc.Initialize(%+v)", params)
 	log.Printf("root path: %q", params.RootPath)
-	s.RootPath = params.RootPath
-	result.Capabilities = lsp.ServerCapabilities{
-		HoverProvider:          true,
-		DocumentSymbolProvider: true,
-		DefinitionProvider:     true,
-		ReferencesProvider:     true,
+	s.RootPath = strings.TrimPrefix(params.RootPath, "file://")
+	s.hierarchicalSymbols = params.Capabilities.TextDocument.DocumentSymbol.HierarchicalDocumentSymbolSupport
+	s.releaseWorkspace()
+	if s.RootPath != "" {
+		s.workspace = acquireWorkspace(s.RootPath)
+	}
+
+	// Only advertise what is wired up in methods and actually
+	// answered; the remaining LangSvc methods are stubs.
+	result.Capabilities.ServerCapabilities = lsp.ServerCapabilities{
+		TextDocumentSync:          lsp.TDSKIncremental,
+		CompletionProvider:        &lsp.CompletionOptions{ResolveProvider: true},
+		SignatureHelpProvider:     &lsp.SignatureHelpOptions{TriggerCharacters: []string{"(", ","}},
+		HoverProvider:             true,
+		DocumentSymbolProvider:    true,
+		DefinitionProvider:        true,
+		ReferencesProvider:        true,
+		WorkspaceSymbolProvider:   true,
+		DocumentHighlightProvider: true,
+		CodeLensProvider:          &lsp.CodeLensOptions{ResolveProvider: true},
 	}
+	result.Capabilities.ImplementationProvider = true
+	result.Capabilities.TypeHierarchyProvider = true
+	result.Capabilities.TypeDefinitionProvider = true
+	result.Capabilities.FoldingRangeProvider = true
+	result.Capabilities.RenameProvider = &renameOptions{PrepareProvider: true}
 
 	return nil
 }
-func (s *LangSvc) Completion(params *lsp.TextDocumentPositionParams, result *lsp.CompletionList) error {
+func (s *LangSvc) DidOpen(ctx context.Context, params *lsp.DidOpenTextDocumentParams, result *struct{}) error {
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	s.overlay.open(docURL.Path, params.TextDocument.Version, params.TextDocument.Text)
+	return nil
+}
+func (s *LangSvc) DidChange(ctx context.Context, params *lsp.DidChangeTextDocumentParams, result *struct{}) error {
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	return s.overlay.change(docURL.Path, params.TextDocument.Version, params.ContentChanges)
+}
+func (s *LangSvc) DidClose(ctx context.Context, params *lsp.DidCloseTextDocumentParams, result *struct{}) error {
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	s.overlay.close(docURL.Path)
 	return nil
 }
-func (s *LangSvc) CompletionItemResolve(params *lsp.CompletionList, result *lsp.CompletionList) error {
+func (s *LangSvc) DidSave(ctx context.Context, params *lsp.DidSaveTextDocumentParams, result *struct{}) error {
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	s.overlay.saved(docURL.Path)
+
+	// Re-tag the saved file. If the index is still being built, it
+	// will pick up the file from disk itself. Running ctags can take a
+	// while, and notifications are handled in order, so do it in the
+	// background rather than hold up the messages that follow. Saves
+	// in quick succession may finish out of order; only the tags of
+	// the latest are kept.
+	ix := s.index()
+	if ix == nil {
+		return nil
+	}
+	w, path := s.workspace, docURL.Path
+	gen := w.startRetag(path)
+	s.retags.Add(1)
+	go func() {
+		defer s.retags.Done()
+		p, err := ctags.ParseFilesContext(s.retagCtx, []string{path})
+		if err != nil {
+			if s.retagCtx.Err() == nil {
+				log.Printf("! could not re-tag %s: %s", path, err)
+			}
+			return
+		}
+		w.finishRetag(path, gen, p.Tags())
+	}()
 	return nil
 }
-func (s *LangSvc) Hover(params *lsp.TextDocumentPositionParams, result *lsp.Hover) error {
-	result.Contents = []lsp.MarkedString{{Language: "markdown", Value: "Hello CTags!"}}
+func (s *LangSvc) Completion(ctx context.Context, params *lsp.TextDocumentPositionParams, result *lsp.CompletionList) error {
+	log.Printf("Completion(%+v)", params)
+
+	result.Items = []lsp.CompletionItem{}
+	file, err := s.fetchFile(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(file, "\n")
+	if params.Position.Line < 0 || params.Position.Line >= len(lines) {
+		return nil
+	}
+	ix := s.index()
+	if ix == nil {
+		// Ask again once the index is ready.
+		result.IsIncomplete = true
+		return nil
+	}
+
+	line := lines[params.Position.Line]
+	prefix, start := completionPrefix(line, byteColumn(line, params.Position.Character), syntaxOf(fileLanguage(ix, docURL.Path)))
+	if prefix == "" {
+		result.IsIncomplete = true
+		return nil
+	}
+	qualifier := qualifierBefore(line, start)
+
+	tags := rankCompletions(s.completionTags(ctx, ix, docURL.Path, prefix), definitionScorer(docURL.Path, file, qualifier, ix))
+	if len(tags) > maxCompletionItems {
+		tags = tags[:maxCompletionItems]
+		result.IsIncomplete = true
+	}
+	for i, tag := range tags {
+		item := completionItem(tag)
+		item.SortText = fmt.Sprintf("%04d", i)
+		result.Items = append(result.Items, item)
+	}
 	return nil
 }
-func (s *LangSvc) SignatureHelpRequest(params *lsp.TextDocumentPositionParams, result *lsp.SignatureHelp) error {
+func (s *LangSvc) CompletionItemResolve(ctx context.Context, params *lsp.CompletionItem, result *lsp.CompletionItem) error {
+	*result = *params
+	if params.Data == nil {
+		return nil
+	}
+	var data tagRef
+	if err := decodeData(params.Data, &data); err != nil {
+		return err
+	}
+	tag, ok := s.resolveTagRef(ctx, data)
+	if !ok {
+		return nil
+	}
+	contents, err := s.fetchFile("file://" + tag.File)
+	if err != nil {
+		return nil
+	}
+	result.Documentation = docComment(strings.Split(contents, "\n"), tag.Line, tag.Language)
 	return nil
 }
-func (s *LangSvc) GoToDefinition(params *lsp.TextDocumentPositionParams, result *[]lsp.Location) error {
-	log.Printf("GoToDefinition(%+v)", params)
+func (s *LangSvc) Hover(ctx context.Context, params *lsp.TextDocumentPositionParams, result **hover) error {
+	log.Printf("Hover(%+v)", params)
 
-	file, err := fetchFile(params.TextDocument.URI)
+	matchedTags, loc, err := s.definitions(ctx, params)
 	if err != nil {
 		return err
 	}
-	token, _ := extractTokenFromPosition(file, params.Position.Line, params.Position.Character) // token to search for
+	if len(matchedTags) == 0 {
+		return nil // a null result
+	}
+	*result = &hover{
+		Contents: markupContent{Kind: "markdown", Value: s.hoverMarkdown(matchedTags[0])},
+		Range:    loc,
+	}
+	return nil
+}
+func (s *LangSvc) SignatureHelpRequest(ctx context.Context, params *lsp.TextDocumentPositionParams, result *lsp.SignatureHelp) error {
+	log.Printf("SignatureHelpRequest(%+v)", params)
 
-	log.Printf("search around for token %q", token)
+	result.Signatures = []lsp.SignatureInformation{}
+	file, err := s.fetchFile(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	ix := s.index()
+	lang := fileLanguage(ix, docURL.Path)
+	if noParenCalls[lang] {
+		return nil
+	}
+	starts := lineStarts(file)
+	if params.Position.Line < 0 || params.Position.Line >= len(starts) {
+		return nil
+	}
+	lineStart := starts[params.Position.Line]
+	c, ok := enclosingCall(file, lineStart+byteColumn(file[lineStart:], params.Position.Character), syntaxOf(lang))
+	if !ok {
+		return nil
+	}
 
-	var matchedTags []ctags.Tag
-	{
-		docURL, err := url.Parse(params.TextDocument.URI)
-		if err != nil {
-			return err
-		}
-		searchDir := filepath.Dir(docURL.Path)
-		dirfiles, err := ioutil.ReadDir(searchDir)
-		if err != nil {
-			return err
-		}
-		for _, file := range dirfiles {
-			if file.IsDir() {
-				continue
-			}
-			parser, err := ctags.Parse2([]string{filepath.Join(searchDir, file.Name())})
-			if err != nil {
-				return err
-			}
-			for _, tag := range parser.Tags() {
-				if tag.Name == token {
-					matchedTags = append(matchedTags, tag)
-				}
-			}
+	tags, err := s.lookupTags(ctx, ix, docURL.Path, c.callee)
+	if err != nil {
+		return err
+	}
+	rankDefinitions(tags, docURL.Path, file, c.qualifier, ix)
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if tag.Signature == "" || seen[tag.Signature] {
+			continue
 		}
+		seen[tag.Signature] = true
+		result.Signatures = append(result.Signatures, s.signatureInformation(tag))
 	}
+	result.ActiveParameter = c.arg
+	return nil
+}
+func (s *LangSvc) GoToDefinition(ctx context.Context, params *lsp.TextDocumentPositionParams, result *[]lsp.Location) error {
+	log.Printf("GoToDefinition(%+v)", params)
 
+	matchedTags, _, err := s.definitions(ctx, params)
+	if err != nil {
+		return err
+	}
 	log.Printf("matched %d tags", len(matchedTags))
 
 	symbols := tagsToSymbolInformation(matchedTags)
@@ -93,88 +306,399 @@ func (s *LangSvc) GoToDefinition(params *lsp.TextDocumentPositionParams, result
 	*result = locs
 	return nil
 }
-func (s *LangSvc) References(params *lsp.ReferenceParams, result *[]lsp.Location) error {
-	log.Printf("References(%+v)", params)
 
-	file, err := fetchFile(params.TextDocument.URI)
+// definitions returns the ranked definitions of the token at the
+// given position, along with the token's range.
+func (s *LangSvc) definitions(ctx context.Context, params *lsp.TextDocumentPositionParams) ([]ctags.Tag, lsp.Range, error) {
+	file, err := s.fetchFile(params.TextDocument.URI)
+	if err != nil {
+		return nil, lsp.Range{}, err
+	}
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return nil, lsp.Range{}, err
+	}
+	ix := s.index()
+	token, loc := extractTokenFromPosition(file, params.Position.Line, params.Position.Character, syntaxOf(fileLanguage(ix, docURL.Path))) // token to search for
+	if token == "" {
+		return nil, loc, nil
+	}
+	line := lineAt(file, loc.Start.Line)
+	qualifier := qualifierBefore(line, byteColumn(line, loc.Start.Character))
+
+	matchedTags, err := s.lookupTags(ctx, ix, docURL.Path, token)
+	if err != nil {
+		return nil, loc, err
+	}
+	rankDefinitions(matchedTags, docURL.Path, file, qualifier, ix)
+	return matchedTags, loc, nil
+}
+
+// lookupTags returns the tags named name: from the index if there is
+// one, with the tags of the document at docPath taken from its unsaved
+// contents if it has any, and otherwise from the document's directory.
+func (s *LangSvc) lookupTags(ctx context.Context, ix *index.Index, docPath, name string) ([]ctags.Tag, error) {
+	if ix == nil {
+		log.Printf("search around for token %q", name)
+		return dirTags(ctx, filepath.Dir(docPath), name)
+	}
+	log.Printf("look up token %q in workspace index", name)
+	tags := ix.Lookup(name)
+	if bufTags, ok, err := s.overlay.dirtyTags(ctx, docPath); err != nil {
+		log.Printf("! could not tag buffer %s: %s", docPath, err)
+	} else if ok {
+		// The index has the tags of the saved file; the open
+		// document's own tags supersede them.
+		tags = append(withoutFile(tags, docPath), tagsNamed(bufTags, name)...)
+	}
+	return tags, nil
+}
+
+// documentTags returns the tags of the document at path: of its unsaved
+// contents if it has any, else as indexed, else as tagged afresh.
+func (s *LangSvc) documentTags(ctx context.Context, ix *index.Index, path string) ([]ctags.Tag, error) {
+	if tags, ok, err := s.overlay.dirtyTags(ctx, path); err != nil || ok {
+		return tags, err
+	}
+	if ix != nil && ix.HasFile(path) {
+		return ix.FileTags(path), nil
+	}
+	p, err := ctags.ParseFilesContext(ctx, []string{path})
+	if err != nil {
+		return nil, err
+	}
+	return p.Tags(), nil
+}
+
+// index returns the workspace index, or nil if there is no workspace
+// or its index is not ready.
+func (s *LangSvc) index() *index.Index {
+	if s.workspace == nil {
+		return nil
+	}
+	return s.workspace.Index(indexWait)
+}
+func (s *LangSvc) TypeDefinition(ctx context.Context, params *lsp.TextDocumentPositionParams, result *[]lsp.Location) error {
+	log.Printf("TypeDefinition(%+v)", params)
+
+	*result = []lsp.Location{}
+	ix := s.index()
+	if ix == nil {
+		return nil
+	}
+	defs, _, err := s.definitions(ctx, params)
 	if err != nil {
 		return err
 	}
-	token, _ := extractTokenFromPosition(file, params.Position.Line, params.Position.Character)
+	for _, sym := range tagsToSymbolInformation(typeDefinitions(ix, defs)) {
+		*result = append(*result, sym.Location)
+	}
+	return nil
+}
+func (s *LangSvc) Implementation(ctx context.Context, params *lsp.TextDocumentPositionParams, result *[]lsp.Location) error {
+	log.Printf("Implementation(%+v)", params)
 
-	var matchedRefs []lsp.Location
-	{
-		docURL, err := url.Parse(params.TextDocument.URI)
-		if err != nil {
-			return err
+	*result = []lsp.Location{}
+	ix := s.index()
+	if ix == nil {
+		return nil
+	}
+	defs, _, err := s.definitions(ctx, params)
+	if err != nil {
+		return err
+	}
+	for _, sym := range tagsToSymbolInformation(s.workspace.inheritanceGraph().implementations(defs)) {
+		*result = append(*result, sym.Location)
+	}
+	return nil
+}
+func (s *LangSvc) PrepareTypeHierarchy(ctx context.Context, params *lsp.TextDocumentPositionParams, result *[]typeHierarchyItem) error {
+	defs, _, err := s.definitions(ctx, params)
+	if err != nil {
+		return err
+	}
+	var types []ctags.Tag
+	for _, def := range defs {
+		if typeKinds[def.Kind] {
+			types = append(types, def)
 		}
-		searchDir := filepath.Dir(docURL.Path)
-		dirfiles, err := ioutil.ReadDir(searchDir)
+	}
+	*result = typeHierarchyItems(types)
+	return nil
+}
+func (s *LangSvc) TypeHierarchySupertypes(ctx context.Context, params *typeHierarchyParams, result *[]typeHierarchyItem) error {
+	return s.typeHierarchy(ctx, params, result, (*inheritanceGraph).supertypes)
+}
+func (s *LangSvc) TypeHierarchySubtypes(ctx context.Context, params *typeHierarchyParams, result *[]typeHierarchyItem) error {
+	return s.typeHierarchy(ctx, params, result, func(g *inheritanceGraph, tag ctags.Tag) []ctags.Tag {
+		return g.subtypes(tag.Name)
+	})
+}
+
+// typeHierarchy answers typeHierarchy/supertypes and subtypes with the
+// types related to params.Item by related.
+func (s *LangSvc) typeHierarchy(ctx context.Context, params *typeHierarchyParams, result *[]typeHierarchyItem, related func(*inheritanceGraph, ctags.Tag) []ctags.Tag) error {
+	*result = []typeHierarchyItem{}
+	ix := s.index()
+	if ix == nil {
+		return nil
+	}
+	var ref tagRef
+	if err := decodeData(params.Item.Data, &ref); err != nil {
+		return err
+	}
+	tag, ok := s.resolveTagRef(ctx, ref)
+	if !ok {
+		return fmt.Errorf("type %s is no longer in the index", params.Item.Name)
+	}
+	*result = typeHierarchyItems(related(s.workspace.inheritanceGraph(), tag))
+	return nil
+}
+func (s *LangSvc) References(ctx context.Context, params *lsp.ReferenceParams, result *[]lsp.Location) error {
+	log.Printf("References(%+v)", params)
+
+	file, err := s.fetchFile(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	ix := s.index()
+	token, _ := extractTokenFromPosition(file, params.Position.Line, params.Position.Character, syntaxOf(fileLanguage(ix, docURL.Path)))
+	*result = []lsp.Location{}
+	if token == "" {
+		return nil
+	}
+
+	var decls map[string]map[int]int
+	if !params.Context.IncludeDeclaration {
+		defs, _, err := s.definitions(ctx, &params.TextDocumentPositionParams)
 		if err != nil {
 			return err
 		}
-		for _, file := range dirfiles {
-			if file.IsDir() {
-				continue
-			}
-			b, err := ioutil.ReadFile(filepath.Join(searchDir, file.Name()))
-			if err != nil {
-				return err
-			}
-			lines := strings.Split(string(b), "\n")
-
-			for l, line := range lines {
-				if c := strings.Index(line, token); c != -1 {
-					matchedRefs = append(matchedRefs, lsp.Location{
-						URI: "file://" + filepath.Join(searchDir, file.Name()),
-						Range: lsp.Range{
-							Start: lsp.Position{Line: l, Character: c},
-							End:   lsp.Position{Line: l, Character: c + len(token)},
-						},
-					})
-				}
-			}
-		}
+		decls = declarationSites(defs)
 	}
 
-	*result = matchedRefs
+	occs, err := s.occurrences(ctx, ix, docURL.Path, token, s.SkipCommentsAndStrings)
+	if err != nil {
+		return err
+	}
+	for _, occ := range occs {
+		if col, ok := decls[occ.File][occ.Range.Start.Line+1]; ok && occ.Col >= col {
+			// Drop the declaration itself, but not later uses on the
+			// same line.
+			delete(decls[occ.File], occ.Range.Start.Line+1)
+			continue
+		}
+		*result = append(*result, occ.Location())
+	}
 	return nil
 }
-func (s *LangSvc) DocumentHighlights(params *lsp.ReferenceParams, result *lsp.DocumentHighlight) error {
+func (s *LangSvc) DocumentHighlights(ctx context.Context, params *lsp.TextDocumentPositionParams, result *[]lsp.DocumentHighlight) error {
+	log.Printf("DocumentHighlights(%+v)", params)
+
+	*result = []lsp.DocumentHighlight{}
+	file, err := s.fetchFile(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	ix := s.index()
+	syn := syntaxOf(fileLanguage(ix, docURL.Path))
+	token, _ := extractTokenFromPosition(file, params.Position.Line, params.Position.Character, syn)
+	if token == "" {
+		return nil
+	}
+	tags, err := s.documentTags(ctx, ix, docURL.Path)
+	if err != nil {
+		return err
+	}
+	defs := declarationSites(tagsNamed(tags, token))[docURL.Path]
+
+	// Highlights cover mentions in comments and strings too, whatever
+	// the config says for references: they only show, not edit.
+	starts := lineStarts(file)
+	for _, off := range findOccurrences(file, token, syn, false) {
+		pos := positionOf(file, starts, off)
+		kind := lsp.Read
+		if col, ok := defs[pos.Line+1]; ok && off-starts[pos.Line] >= col {
+			delete(defs, pos.Line+1)
+			kind = lsp.Write
+		}
+		*result = append(*result, lsp.DocumentHighlight{
+			Range: lsp.Range{
+				Start: pos,
+				End:   lsp.Position{Line: pos.Line, Character: pos.Character + utf16Len(token)},
+			},
+			Kind: kind,
+		})
+	}
 	return nil
 }
-func (s *LangSvc) DocumentSymbols(params *lsp.DocumentSymbolParams, result *[]lsp.SymbolInformation) error {
+
+// DocumentSymbols returns a []documentSymbol to clients that support
+// hierarchical document symbols, and a []lsp.SymbolInformation to
+// others.
+func (s *LangSvc) DocumentSymbols(ctx context.Context, params *lsp.DocumentSymbolParams, result *interface{}) error {
 	docURL, err := url.Parse(params.TextDocument.URI)
 	if err != nil {
 		return err
 	}
+	tags, err := s.documentTags(ctx, s.index(), docURL.Path)
+	if err != nil {
+		return err
+	}
 
-	parser, err := ctags.Parse2([]string{docURL.Path})
+	if !s.hierarchicalSymbols {
+		*result = tagsToSymbolInformation(tags)
+		return nil
+	}
+	file, err := s.fetchFile(params.TextDocument.URI)
 	if err != nil {
 		return err
 	}
-	*result = tagsToSymbolInformation(parser.Tags())
+	*result = documentSymbols(index.FileTree(tags), strings.Split(file, "\n"))
 	return nil
 }
-func (s *LangSvc) WorkspaceSymbols(params *lsp.WorkspaceSymbolParams, result *[]lsp.SymbolInformation) error {
+func (s *LangSvc) FoldingRanges(ctx context.Context, params *foldingRangeParams, result *[]foldingRange) error {
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	file, err := s.fetchFile(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	ix := s.index()
+	tags, err := s.documentTags(ctx, ix, docURL.Path)
+	if err != nil {
+		return err
+	}
+	*result = foldingRanges(strings.Split(file, "\n"), tags, fileLanguage(ix, docURL.Path))
+	if *result == nil {
+		*result = []foldingRange{}
+	}
 	return nil
 }
-func (s *LangSvc) CodeAction(params *lsp.CodeActionParams, result *[]lsp.Command) error {
+func (s *LangSvc) WorkspaceSymbols(ctx context.Context, params *lsp.WorkspaceSymbolParams, result *[]lsp.SymbolInformation) error {
+	log.Printf("WorkspaceSymbols(%+v)", params)
+
+	*result = []lsp.SymbolInformation{}
+	ix := s.index()
+	if ix == nil {
+		return nil
+	}
+
+	// The index ranks matches by the first word of the name; the
+	// others only narrow them down.
+	q := parseSymbolQuery(params.Query)
+	var first string
+	var rest []string
+	if len(q.names) > 0 {
+		first, rest = q.names[0], q.names[1:]
+	}
+	matches := ix.Search(index.Query{Name: first, Mode: index.Fuzzy, Filter: q.filter})
+	kept := matches[:0]
+	for _, m := range matches {
+		if matchesPaths(s.RootPath, m.File, q.paths) && matchesNames(m.Name, rest) {
+			kept = append(kept, m)
+		}
+	}
+	sort.Sort(bySymbolRank(kept))
+
+	tags := make([]ctags.Tag, 0, maxWorkspaceSymbols)
+	for _, m := range kept {
+		if len(tags) == maxWorkspaceSymbols {
+			break
+		}
+		tags = append(tags, m.Tag)
+	}
+	*result = tagsToSymbolInformation(tags)
 	return nil
 }
-func (s *LangSvc) CodeLensRequest(params *lsp.CodeLensParams, result *[]lsp.Command) error {
+func (s *LangSvc) CodeAction(ctx context.Context, params *lsp.CodeActionParams, result *[]lsp.Command) error {
 	return nil
 }
-func (s *LangSvc) CodeLensResolve(params *lsp.CodeLens, result *lsp.CodeLens) error {
+func (s *LangSvc) CodeLensRequest(ctx context.Context, params *lsp.CodeLensParams, result *[]lsp.CodeLens) error {
+	log.Printf("CodeLensRequest(%+v)", params)
+
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	tags, err := s.documentTags(ctx, s.index(), docURL.Path)
+	if err != nil {
+		return err
+	}
+	// Counting references takes a pass over the workspace, so lenses
+	// are only counted as the client resolves them.
+	*result = codeLenses(tags)
+	if *result == nil {
+		*result = []lsp.CodeLens{}
+	}
 	return nil
 }
-func (s *LangSvc) DocumentFormatting(params *lsp.DocumentFormattingParams, result *[]lsp.TextEdit) error {
+func (s *LangSvc) CodeLensResolve(ctx context.Context, params *lsp.CodeLens, result *lsp.CodeLens) error {
+	*result = *params
+	if params.Data == nil {
+		return nil
+	}
+	var data codeLensData
+	if err := decodeData(params.Data, &data); err != nil {
+		return err
+	}
+
+	var locs []lsp.Location
+	switch data.Type {
+	case lensReferences:
+		var err error
+		if locs, err = s.resolveReferencesLens(ctx, data); err != nil {
+			return err
+		}
+	case lensImplementations:
+		locs = s.resolveImplementationsLens(data)
+	default:
+		return fmt.Errorf("unknown code lens type %q", data.Type)
+	}
+	result.Command = lsp.Command{
+		Title:     countTitle(len(locs), data.Type),
+		Command:   showReferences,
+		Arguments: []interface{}{"file://" + data.File, params.Range.Start, locs},
+	}
 	return nil
 }
-func (s *LangSvc) DocumentOnTypeFormatting(params *lsp.DocumentFormattingParams, result *[]lsp.TextEdit) error {
+func (s *LangSvc) DocumentFormatting(ctx context.Context, params *lsp.DocumentFormattingParams, result *[]lsp.TextEdit) error {
 	return nil
 }
-func (s *LangSvc) Rename(params *lsp.RenameParams, result *lsp.WorkspaceEdit) error {
+func (s *LangSvc) DocumentOnTypeFormatting(ctx context.Context, params *lsp.DocumentFormattingParams, result *[]lsp.TextEdit) error {
+	return nil
+}
+func (s *LangSvc) Rename(ctx context.Context, params *lsp.RenameParams, result *lsp.WorkspaceEdit) error {
+	log.Printf("Rename(%+v)", params)
+
+	pos := &lsp.TextDocumentPositionParams{TextDocument: params.TextDocument, Position: params.Position}
+	t, err := s.renameTarget(ctx, pos)
+	if err != nil {
+		return err
+	}
+	if !validIdentifier(params.NewName, t.def.Language) {
+		return fmt.Errorf("cannot rename %s: %q is not a valid identifier", t.token, params.NewName)
+	}
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	edit, err := s.renameEdit(ctx, t, docURL.Path, params.NewName)
+	if err != nil {
+		return err
+	}
+	log.Printf("renaming %s to %s in %v", t.token, params.NewName, renamedFiles(edit))
+	*result = *edit
 	return nil
 }
 
@@ -199,27 +723,29 @@ var nameToSymbolKind = map[string]lsp.SymbolKind{
 	"array":       lsp.SKArray,
 }
 
+func symbolKind(kind string) lsp.SymbolKind {
+	if k, ok := nameToSymbolKind[kind]; ok {
+		return k
+	}
+	return lsp.SKVariable
+}
+
 func tagsToSymbolInformation(tags []ctags.Tag) []lsp.SymbolInformation {
 	res := make([]lsp.SymbolInformation, 0, len(tags))
 	for _, tag := range tags {
-		nameIdx := strings.Index(tag.DefLinePrefix, tag.Name)
-		if nameIdx < 0 {
+		if !strings.Contains(tag.DefLinePrefix, tag.Name) {
 			log.Printf("! dropping tag because could not find name (%s) in def line prefix (%q)", tag.Name, tag.DefLinePrefix)
 			continue
 		}
-		kind := nameToSymbolKind[tag.Kind]
-		if kind == 0 {
-			kind = lsp.SKVariable
-		}
+		kind := symbolKind(tag.Kind)
+		_, scope := index.SplitScope(tag.Scope, tag.Language)
 		res = append(res, lsp.SymbolInformation{
-			Name: tag.Name,
-			Kind: kind,
+			Name:          tag.Name,
+			Kind:          kind,
+			ContainerName: strings.Join(scope, "."),
 			Location: lsp.Location{
-				URI: "file://" + tag.File,
-				Range: lsp.Range{
-					Start: lsp.Position{Line: tag.Line - 1, Character: nameIdx},
-					End:   lsp.Position{Line: tag.Line - 1, Character: nameIdx + len(tag.Name)},
-				},
+				URI:   "file://" + tag.File,
+				Range: nameRange(tag.Line-1, tag.DefLinePrefix, tag.Name),
 			},
 		})
 	}
@@ -229,44 +755,50 @@ func tagsToSymbolInformation(tags []ctags.Tag) []lsp.SymbolInformation {
 func etagsToSymbolInformation(tags []ctags.ETag) []lsp.SymbolInformation {
 	res := make([]lsp.SymbolInformation, 0, len(tags))
 	for _, tag := range tags {
-		nameIdx := strings.Index(tag.Def, tag.Name)
 		res = append(res, lsp.SymbolInformation{
 			Name: tag.Name,
 			Kind: lsp.SKMethod, // TODO
 			Location: lsp.Location{
-				URI: "file://" + tag.File,
-				Range: lsp.Range{
-					Start: lsp.Position{Line: tag.Line - 1, Character: nameIdx},
-					End:   lsp.Position{Line: tag.Line - 1, Character: nameIdx + len(tag.Name)},
-				},
+				URI:   "file://" + tag.File,
+				Range: nameRange(tag.Line-1, tag.Def, tag.Name),
 			},
 		})
 	}
 	return res
 }
 
-func extractTokenFromPosition(file string, l int, c int) (token string, loc lsp.Range) {
-	lines := strings.Split(file, "\n")
-	line := lines[l]
-	start := strings.LastIndexAny(line[:c], " \r\n\t()\"'.,*-<>:") + 1
-	end := strings.IndexAny(line[c:], " \r\n\t()\"'.,*-<>:")
-	if end == -1 {
+// extractTokenFromPosition returns the identifier of syn at line l and
+// UTF-16 column c of file, and its range. A position just after an
+// identifier selects it. Positions past the end of a line or of the
+// file are clamped to it.
+func extractTokenFromPosition(file string, l int, c int, syn syntax) (token string, loc lsp.Range) {
+	line := lineAt(file, l)
+	col := byteColumn(line, c)
+	notIdent := func(r rune) bool { return !syn.isIdent(r) }
+	start := 0
+	if i := strings.LastIndexFunc(line[:col], notIdent); i >= 0 {
+		_, size := utf8.DecodeRuneInString(line[i:])
+		start = i + size
+	}
+	end := strings.IndexFunc(line[col:], notIdent)
+	if end < 0 {
 		end = len(line)
 	} else {
-		end += c
-	}
-	return line[start:end], lsp.Range{
-		Start: lsp.Position{Line: l, Character: start},
-		End:   lsp.Position{Line: l, Character: end},
+		end += col
 	}
+	return line[start:end], lineRange(l, line, start, end)
 }
 
-// fetches file contents from URI
-func fetchFile(uri string) (string, error) {
+// fetches file contents from URI, preferring the client's copy of open
+// documents
+func (s *LangSvc) fetchFile(uri string) (string, error) {
 	docURL, err := url.Parse(uri)
 	if err != nil {
 		return "", err
 	}
+	if text, ok := s.overlay.get(docURL.Path); ok {
+		return text, nil
+	}
 	b, err := ioutil.ReadFile(docURL.Path)
 	if err != nil {
 		return "", err
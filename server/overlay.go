@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sourcegraph/tag-server/ctags"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// overlay holds the contents of the documents open in the client, which
+// take precedence over the files on disk.
+type overlay struct {
+	mu   sync.Mutex
+	docs map[string]*document // by file path
+}
+
+// document is an open document.
+type document struct {
+	version int
+	text    string
+
+	// dirty is set when text differs from what was last opened or
+	// saved, i.e. when the file on disk is stale.
+	dirty bool
+
+	// tags caches the tags of text, and tagsVersion the version they
+	// were computed for.
+	tags        []ctags.Tag
+	tagsVersion int
+}
+
+func newOverlay() *overlay {
+	return &overlay{docs: make(map[string]*document)}
+}
+
+func (o *overlay) open(path string, version int, text string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.docs[path] = &document{version: version, text: text, tagsVersion: -1}
+}
+
+func (o *overlay) close(path string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.docs, path)
+}
+
+// saved marks the document at path as matching the file on disk.
+func (o *overlay) saved(path string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if d, ok := o.docs[path]; ok {
+		d.dirty = false
+	}
+}
+
+// change applies the content changes of a didChange notification, in
+// order. Changes without a range replace the whole document.
+func (o *overlay) change(path string, version int, changes []lsp.TextDocumentContentChangeEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	d, ok := o.docs[path]
+	if !ok {
+		return fmt.Errorf("change to document that is not open: %s", path)
+	}
+	if version != 0 && version < d.version {
+		return fmt.Errorf("change to %s is out of order: version %d after %d", path, version, d.version)
+	}
+	text := d.text
+	for _, c := range changes {
+		if c.Range == nil {
+			text = c.Text
+			continue
+		}
+		start, err := offsetAt(text, c.Range.Start)
+		if err != nil {
+			return err
+		}
+		end, err := offsetAt(text, c.Range.End)
+		if err != nil {
+			return err
+		}
+		if end < start {
+			return fmt.Errorf("invalid range %+v", *c.Range)
+		}
+		text = text[:start] + c.Text + text[end:]
+	}
+	d.text, d.version, d.dirty = text, version, true
+	return nil
+}
+
+// get returns the contents of the open document at path.
+func (o *overlay) get(path string) (text string, ok bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if d, ok := o.docs[path]; ok {
+		return d.text, true
+	}
+	return "", false
+}
+
+// dirtyTags returns the tags of the document at path if it is open and
+// has unsaved changes, tagging its current contents as needed. ok is
+// false if the file on disk is current.
+func (o *overlay) dirtyTags(ctx context.Context, path string) (tags []ctags.Tag, ok bool, err error) {
+	o.mu.Lock()
+	d, open := o.docs[path]
+	if !open || !d.dirty {
+		o.mu.Unlock()
+		return nil, false, nil
+	}
+	if d.tagsVersion == d.version {
+		tags = d.tags
+		o.mu.Unlock()
+		return tags, true, nil
+	}
+	version, text := d.version, d.text
+	o.mu.Unlock()
+
+	tags, err = tagBuffer(ctx, path, text)
+	if err != nil {
+		return nil, false, err
+	}
+
+	o.mu.Lock()
+	if d.version == version {
+		d.tags, d.tagsVersion = tags, version
+	}
+	o.mu.Unlock()
+	return tags, true, nil
+}
+
+// tagBuffer runs ctags on text as the contents of the file at path. The
+// text is written to a file of the same name in a temporary directory,
+// so that ctags picks the language from the file name.
+func tagBuffer(ctx context.Context, path, text string) ([]ctags.Tag, error) {
+	dir, err := ioutil.TempDir("", "tag-server")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, filepath.Base(path))
+	if err := ioutil.WriteFile(tmp, []byte(text), 0600); err != nil {
+		return nil, err
+	}
+
+	p, err := ctags.ParseFilesContext(ctx, []string{tmp})
+	if err != nil {
+		return nil, err
+	}
+	tags := p.Tags()
+	for i := range tags {
+		tags[i].File = path
+	}
+	log.Printf("...tagged buffer %s: %d tags", path, len(tags))
+	return tags, nil
+}
+
+// offsetAt returns the byte offset in text of pos, whose character
+// offset counts UTF-16 code units as LSP specifies. Positions past the
+// end of a line are clamped to it.
+func offsetAt(text string, pos lsp.Position) (int, error) {
+	off := 0
+	for l := 0; l < pos.Line; l++ {
+		i := strings.Index(text[off:], "\n")
+		if i < 0 {
+			return 0, fmt.Errorf("position %d:%d is past the end of the document", pos.Line, pos.Character)
+		}
+		off += i + 1
+	}
+	return off + byteColumn(text[off:], pos.Character), nil
+}
+
+// paths returns the paths of the open documents.
+func (o *overlay) paths() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	paths := make([]string, 0, len(o.docs))
+	for path := range o.docs {
+		paths = append(paths, path)
+	}
+	return paths
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// LSP positions count characters in UTF-16 code units, while the
+// server works with byte offsets into UTF-8 text. The functions below
+// are the only place that converts between the two.
+
+// utf16Len returns the length of s in UTF-16 code units.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// byteColumn returns the byte offset in line of the character that is
+// character UTF-16 code units in. Columns past the end of the line,
+// which ends at the first newline, are clamped to it.
+func byteColumn(line string, character int) int {
+	off := 0
+	for units := 0; units < character && off < len(line); {
+		r, size := utf8.DecodeRuneInString(line[off:])
+		if r == '\n' {
+			break
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+		off += size
+	}
+	return off
+}
+
+// lineRange returns the range of the bytes start to end of text, which
+// begins line l.
+func lineRange(l int, text string, start, end int) lsp.Range {
+	return lsp.Range{
+		Start: lsp.Position{Line: l, Character: utf16Len(text[:start])},
+		End:   lsp.Position{Line: l, Character: utf16Len(text[:end])},
+	}
+}
+
+// nameRange returns the range of name on line l, given the text def
+// that the line starts with. If def does not contain name, the range
+// runs for the length of name from the start of the line.
+func nameRange(l int, def, name string) lsp.Range {
+	i := strings.Index(def, name)
+	if i < 0 {
+		return lsp.Range{
+			Start: lsp.Position{Line: l},
+			End:   lsp.Position{Line: l, Character: utf16Len(name)},
+		}
+	}
+	return lineRange(l, def, i, i+len(name))
+}
+
+// lineStarts returns the byte offsets at which the lines of text start.
+func lineStarts(text string) []int {
+	starts := []int{0}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// positionOf converts a byte offset in text to a position, given the
+// line starts of text.
+func positionOf(text string, starts []int, off int) lsp.Position {
+	line := sort.SearchInts(starts, off+1) - 1
+	return lsp.Position{Line: line, Character: utf16Len(text[starts[line]:off])}
+}
+
+// lineAt returns line l of text, without its line ending, or "" if
+// text has no such line.
+func lineAt(text string, l int) string {
+	lines := strings.Split(text, "\n")
+	if l < 0 || l >= len(lines) {
+		return ""
+	}
+	return strings.TrimSuffix(lines[l], "\r")
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"testing"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+func TestUTF16Columns(t *testing.T) {
+	tests := []struct {
+		line  string
+		units int // UTF-16 length of line
+	}{
+		{"", 0},
+		{"abc", 3},
+		{"héllo", 5},
+		{"日本", 2},
+		{"a😀b", 4},
+	}
+	for _, test := range tests {
+		if n := utf16Len(test.line); n != test.units {
+			t.Errorf("utf16Len(%q) = %d, want %d", test.line, n, test.units)
+		}
+		if off := byteColumn(test.line, test.units); off != len(test.line) {
+			t.Errorf("byteColumn(%q, %d) = %d, want %d", test.line, test.units, off, len(test.line))
+		}
+		if off := byteColumn(test.line+"\nnext", test.units+10); off != len(test.line) {
+			t.Errorf("byteColumn(%q, past the end) = %d, want %d", test.line, off, len(test.line))
+		}
+	}
+
+	text := "x := \"😀\"; y\nz"
+	starts := lineStarts(text)
+	if pos := positionOf(text, starts, len("x := \"😀\"; ")); pos != (lsp.Position{Line: 0, Character: 11}) {
+		t.Errorf("positionOf(y) = %+v, want 0:11", pos)
+	}
+	if pos := positionOf(text, starts, len(text)-1); pos != (lsp.Position{Line: 1, Character: 0}) {
+		t.Errorf("positionOf(z) = %+v, want 1:0", pos)
+	}
+}
+
+func TestExtractTokenFromPosition(t *testing.T) {
+	file := "foo;\n!bar && baz[i]\ns := \"é\" + qux\n$var = 1\r\n"
+	tests := []struct {
+		line, char int
+		lang       string
+		token      string
+		start, end int // UTF-16 columns of the token
+	}{
+		{0, 0, "C", "foo", 0, 3},
+		{0, 3, "C", "foo", 0, 3},
+		{1, 1, "C", "bar", 1, 4},
+		{1, 9, "C", "baz", 8, 11},
+		{1, 12, "C", "i", 12, 13},
+		{1, 5, "C", "", 5, 5},
+		{2, 11, "Go", "qux", 11, 14},
+		{2, 100, "Go", "qux", 11, 14},
+		{3, 2, "PHP", "$var", 0, 4},
+		{3, 4, "PHP", "$var", 0, 4},
+		{9, 0, "C", "", 0, 0},
+		{-1, 0, "C", "", 0, 0},
+	}
+	for _, test := range tests {
+		token, loc := extractTokenFromPosition(file, test.line, test.char, syntaxOf(test.lang))
+		if token != test.token {
+			t.Errorf("%d:%d: token %q, want %q", test.line, test.char, token, test.token)
+			continue
+		}
+		if test.token != "" && (loc.Start.Character != test.start || loc.End.Character != test.end || loc.Start.Line != test.line) {
+			t.Errorf("%d:%d: range %+v, want %d:%d-%d", test.line, test.char, loc, test.line, test.start, test.end)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import "sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+
+// This file declares the parts of the protocol that package lsp does
+// not.
+
+// initializeParams extends lsp.InitializeParams with the client
+// capabilities the server acts on, which lsp.ClientCapabilities does
+// not declare.
+type initializeParams struct {
+	lsp.InitializeParams
+	Capabilities clientCapabilities `json:"capabilities"`
+}
+
+type clientCapabilities struct {
+	TextDocument struct {
+		DocumentSymbol struct {
+			HierarchicalDocumentSymbolSupport bool `json:"hierarchicalDocumentSymbolSupport"`
+		} `json:"documentSymbol"`
+	} `json:"textDocument"`
+}
+
+// documentSymbol is a symbol of a document with the symbols nested in
+// it, as returned to clients that support hierarchical document
+// symbols.
+type documentSymbol struct {
+	Name   string         `json:"name"`
+	Detail string         `json:"detail,omitempty"`
+	Kind   lsp.SymbolKind `json:"kind"`
+
+	// Range spans the whole definition, and SelectionRange its name.
+	Range          lsp.Range `json:"range"`
+	SelectionRange lsp.Range `json:"selectionRange"`
+
+	Children []documentSymbol `json:"children,omitempty"`
+}
+
+// initializeResult is lsp.InitializeResult with the extended server
+// capabilities.
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities,omitempty"`
+}
+
+type serverCapabilities struct {
+	lsp.ServerCapabilities
+	ImplementationProvider bool `json:"implementationProvider,omitempty"`
+	TypeHierarchyProvider  bool `json:"typeHierarchyProvider,omitempty"`
+	TypeDefinitionProvider bool `json:"typeDefinitionProvider,omitempty"`
+	FoldingRangeProvider   bool `json:"foldingRangeProvider,omitempty"`
+
+	// RenameProvider shadows the embedded bool, which cannot say that
+	// textDocument/prepareRename is supported.
+	RenameProvider *renameOptions `json:"renameProvider,omitempty"`
+}
+
+type renameOptions struct {
+	PrepareProvider bool `json:"prepareProvider,omitempty"`
+}
+
+// typeHierarchyItem is a type in a type hierarchy.
+type typeHierarchyItem struct {
+	Name   string         `json:"name"`
+	Kind   lsp.SymbolKind `json:"kind"`
+	Detail string         `json:"detail,omitempty"`
+	URI    string         `json:"uri"`
+
+	// Range spans the whole definition, and SelectionRange its name.
+	Range          lsp.Range `json:"range"`
+	SelectionRange lsp.Range `json:"selectionRange"`
+
+	// Data identifies the tag of the item, as tagRef.
+	Data interface{} `json:"data,omitempty"`
+}
+
+type typeHierarchyParams struct {
+	Item typeHierarchyItem `json:"item"`
+}
+
+type foldingRangeParams struct {
+	TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+}
+
+// foldingRange is a range of lines that can be folded.
+type foldingRange struct {
+	StartLine int    `json:"startLine"`
+	EndLine   int    `json:"endLine"`
+	Kind      string `json:"kind,omitempty"` // "comment", "imports" or empty
+}
+
+// hover is lsp.Hover with markup contents, which lsp.MarkedString
+// cannot express: a MarkedString with a language is rendered as a code
+// block, not as markdown.
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    lsp.Range     `json:"range"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"` // "plaintext" or "markdown"
+	Value string `json:"value"`
+}
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// extraIdentRunes lists the runes besides those of isIdentRune that may
+// appear in the identifiers of a language.
+var extraIdentRunes = map[string]string{
+	"Lisp":    "-*+!?<>=/%&",
+	"Scheme":  "-*+!?<>=/%&",
+	"Clojure": "-*+!?<>=/%&",
+	"Ruby":    "?!",
+	"Erlang":  "@",
+	"Make":    "-",
+}
+
+// caseInsensitive lists the languages whose identifiers are not case
+// sensitive.
+var caseInsensitive = map[string]bool{
+	"Ada":     true,
+	"Basic":   true,
+	"Cobol":   true,
+	"Fortran": true,
+	"Pascal":  true,
+	"SQL":     true,
+	"Vera":    true,
+	"VHDL":    true,
+}
+
+// blockComments gives the delimiters of block comments per language.
+// Languages not listed use the C-style delimiters; those listed with
+// none have no block comments.
+var blockComments = map[string][]string{
+	"Lua":     {"--[[", "]]"},
+	"Pascal":  {"{", "}"},
+	"HTML":    {"<!--", "-->"},
+	"Python":  nil,
+	"Ruby":    nil,
+	"Perl":    nil,
+	"Sh":      nil,
+	"Make":    nil,
+	"R":       nil,
+	"Tcl":     nil,
+	"Vim":     nil,
+	"Lisp":    nil,
+	"Scheme":  nil,
+	"Clojure": nil,
+	"Erlang":  nil,
+	"Tex":     nil,
+	"Fortran": nil,
+	"Ada":     nil,
+}
+
+var defaultBlockComment = []string{"/*", "*/"}
+
+// stringQuotes lists the string delimiters of a language. Quotes in
+// rawQuotes span lines and have no escapes. Languages not listed use
+// defaultStringQuotes.
+var (
+	stringQuotes = map[string][]string{
+		"Python":     {`"""`, `'''`, `"`, `'`},
+		"Lisp":       {`"`},
+		"Scheme":     {`"`},
+		"Clojure":    {`"`},
+		"Rust":       {`"`},
+		"OCaml":      {`"`},
+		"Vim":        {`'`},
+		"Go":         {"`", `"`, `'`},
+		"JavaScript": {"`", `"`, `'`},
+		"TypeScript": {"`", `"`, `'`},
+	}
+	rawQuotes = map[string]bool{"`": true, `"""`: true, `'''`: true}
+
+	defaultStringQuotes = []string{`"`, `'`}
+)
+
+// extLanguages maps file extensions to ctags language names, for files
+// that are not in the index.
+var extLanguages = map[string]string{
+	".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".cxx": "C++", ".hpp": "C++",
+	".cs": "C#", ".go": "Go", ".java": "Java", ".js": "JavaScript", ".ts": "TypeScript",
+	".m": "ObjectiveC", ".rs": "Rust", ".php": "PHP", ".py": "Python", ".rb": "Ruby",
+	".pl": "Perl", ".pm": "Perl", ".sh": "Sh", ".lua": "Lua", ".sql": "SQL",
+	".lisp": "Lisp", ".el": "Lisp", ".scm": "Scheme", ".clj": "Clojure", ".erl": "Erlang",
+	".scala": "Scala", ".f": "Fortran", ".f90": "Fortran", ".adb": "Ada", ".ads": "Ada",
+	".pas": "Pascal", ".tcl": "Tcl", ".vim": "Vim", ".tex": "Tex", ".r": "R", ".R": "R",
+}
+
+// fileLanguage returns the ctags language of file, as indexed or else
+// as guessed from its name.
+func fileLanguage(ix *index.Index, file string) string {
+	if ix != nil {
+		if tags := ix.FileTags(file); len(tags) > 0 {
+			return tags[0].Language
+		}
+	}
+	if filepath.Base(file) == "Makefile" {
+		return "Make"
+	}
+	return extLanguages[filepath.Ext(file)]
+}
+
+// syntax is what findOccurrences needs to know of a language.
+type syntax struct {
+	lineComments []string
+	blockComment []string // start and end delimiters, or nil
+	quotes       []string
+	identRunes   string
+	foldCase     bool
+}
+
+func syntaxOf(lang string) syntax {
+	syn := syntax{
+		lineComments: defaultLineComments,
+		blockComment: defaultBlockComment,
+		quotes:       defaultStringQuotes,
+		identRunes:   extraIdentRunes[lang],
+		foldCase:     caseInsensitive[lang],
+	}
+	if c, ok := lineComments[lang]; ok {
+		syn.lineComments = c
+	}
+	if c, ok := blockComments[lang]; ok {
+		syn.blockComment = c
+	}
+	if q, ok := stringQuotes[lang]; ok {
+		syn.quotes = q
+	}
+	return syn
+}
+
+func (syn syntax) isIdent(r rune) bool {
+	return isIdentRune(r) || strings.ContainsRune(syn.identRunes, r)
+}
+
+// findOccurrences returns the byte offsets in text at which token occurs
+// as a whole identifier. If skipCommentsAndStrings is set, occurrences
+// in comments and string literals are left out.
+func findOccurrences(text, token string, syn syntax, skipCommentsAndStrings bool) []int {
+	var offsets []int
+	for i := 0; i < len(text); {
+		rest := text[i:]
+		if skipCommentsAndStrings {
+			if n := skipCommentOrString(rest, syn); n > 0 {
+				i += n
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(rest)
+		if !syn.isIdent(r) {
+			i += size
+			continue
+		}
+		end := strings.IndexFunc(rest, func(r rune) bool { return !syn.isIdent(r) })
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		if word == token || (syn.foldCase && strings.EqualFold(word, token)) {
+			offsets = append(offsets, i)
+		}
+		i += end
+	}
+	return offsets
+}
+
+// skipCommentOrString returns the length of the comment or string
+// literal that text starts with, or 0 if it starts with neither.
+func skipCommentOrString(text string, syn syntax) int {
+	for _, m := range syn.lineComments {
+		if strings.HasPrefix(text, m) {
+			if i := strings.Index(text, "\n"); i >= 0 {
+				return i
+			}
+			return len(text)
+		}
+	}
+	if syn.blockComment != nil && strings.HasPrefix(text, syn.blockComment[0]) {
+		start, end := syn.blockComment[0], syn.blockComment[1]
+		if i := strings.Index(text[len(start):], end); i >= 0 {
+			return len(start) + i + len(end)
+		}
+		return len(text)
+	}
+	for _, q := range syn.quotes {
+		if !strings.HasPrefix(text, q) {
+			continue
+		}
+		if rawQuotes[q] {
+			if i := strings.Index(text[len(q):], q); i >= 0 {
+				return len(q) + i + len(q)
+			}
+			return len(text)
+		}
+		// An ordinary string ends at its closing quote or, unterminated,
+		// at the end of the line.
+		for i := len(q); i < len(text); i++ {
+			switch {
+			case text[i] == '\\':
+				i++
+			case text[i] == '\n':
+				return i
+			case strings.HasPrefix(text[i:], q):
+				return i + len(q)
+			}
+		}
+		return len(text)
+	}
+	return 0
+}
+
+// occurrence is an occurrence of an identifier.
+type occurrence struct {
+	File  string
+	Range lsp.Range
+	Line  string // text of the line it is on
+	Col   int    // byte offset in Line at which it starts
+}
+
+func (o occurrence) Location() lsp.Location {
+	return lsp.Location{URI: "file://" + o.File, Range: o.Range}
+}
+
+// occurrences returns the whole-word occurrences of token in the files
+// searched for references, in file and then position order.
+func (s *LangSvc) occurrences(ctx context.Context, ix *index.Index, docPath, token string, skipCommentsAndStrings bool) ([]occurrence, error) {
+	files, err := s.referenceFiles(ix, docPath)
+	if err != nil {
+		return nil, err
+	}
+	var occs []occurrence
+	for _, path := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		text, err := s.fetchFile("file://" + path)
+		if err != nil {
+			log.Printf("! skipping %s: %s", path, err)
+			continue
+		}
+		syn := syntaxOf(fileLanguage(ix, path))
+		if !syn.foldCase && !strings.Contains(text, token) {
+			continue
+		}
+
+		starts := lineStarts(text)
+		for _, off := range findOccurrences(text, token, syn, skipCommentsAndStrings) {
+			pos := positionOf(text, starts, off)
+			line := text[starts[pos.Line]:]
+			if i := strings.Index(line, "\n"); i >= 0 {
+				line = line[:i]
+			}
+			occs = append(occs, occurrence{
+				File: path,
+				Range: lsp.Range{
+					Start: pos,
+					End:   lsp.Position{Line: pos.Line, Character: pos.Character + utf16Len(token)},
+				},
+				Line: line,
+				Col:  off - starts[pos.Line],
+			})
+		}
+	}
+	return occs, nil
+}
+
+// referenceFiles returns the files to search for references: the
+// indexed workspace files and open documents, or while there is no
+// index, the files in the document's directory.
+func (s *LangSvc) referenceFiles(ix *index.Index, docPath string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	if ix != nil {
+		for _, file := range ix.Files() {
+			add(file)
+		}
+	} else {
+		dir := filepath.Dir(docPath)
+		dirfiles, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range dirfiles {
+			if !file.IsDir() {
+				add(filepath.Join(dir, file.Name()))
+			}
+		}
+	}
+	for _, file := range s.overlay.paths() {
+		if ix != nil || filepath.Dir(file) == filepath.Dir(docPath) {
+			add(file)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// declarationSites returns where the names of tags are declared: the
+// column of the name on each tag's line (1-based), by file.
+func declarationSites(tags []ctags.Tag) map[string]map[int]int {
+	sites := make(map[string]map[int]int)
+	for _, tag := range tags {
+		if sites[tag.File] == nil {
+			sites[tag.File] = make(map[int]int)
+		}
+		col := strings.Index(tag.DefLinePrefix, tag.Name)
+		if col < 0 {
+			col = 0
+		}
+		sites[tag.File][tag.Line] = col
+	}
+	return sites
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// declarationKinds are the tag kinds that declare a symbol defined
+// elsewhere, such as C function prototypes. They do not count as
+// competing definitions when deciding whether a rename is ambiguous.
+var declarationKinds = map[string]bool{
+	"prototype":   true,
+	"externvar":   true,
+	"declaration": true,
+}
+
+// prepareRenameResult is the result of textDocument/prepareRename: the
+// range of the symbol to rename and the name to offer for editing.
+type prepareRenameResult struct {
+	Range       lsp.Range `json:"range"`
+	Placeholder string    `json:"placeholder"`
+}
+
+// renameTarget is a symbol that can be renamed safely.
+type renameTarget struct {
+	token string
+	loc   lsp.Range
+	def   ctags.Tag
+
+	// qualifier, if set, picked def among definitions in other scopes,
+	// so only the occurrences qualified by it are the symbol's.
+	qualifier string
+}
+
+// renameTarget resolves the symbol at a position, or returns an error
+// saying why it cannot be renamed.
+func (s *LangSvc) renameTarget(ctx context.Context, params *lsp.TextDocumentPositionParams) (*renameTarget, error) {
+	file, err := s.fetchFile(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	tags, loc, err := s.definitions(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	docURL, err := url.Parse(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	token, _ := extractTokenFromPosition(file, params.Position.Line, params.Position.Character, syntaxOf(fileLanguage(s.index(), docURL.Path)))
+	if token == "" {
+		return nil, fmt.Errorf("no symbol at %d:%d", params.Position.Line+1, params.Position.Character+1)
+	}
+
+	var defs []ctags.Tag
+	for _, tag := range tags {
+		if !declarationKinds[tag.Kind] {
+			defs = append(defs, tag)
+		}
+	}
+	if len(defs) == 0 && len(tags) > 0 {
+		defs = tags[:1]
+	}
+	switch len(defs) {
+	case 0:
+		return nil, fmt.Errorf("cannot rename %s: no definition found in the workspace", token)
+	case 1:
+		return &renameTarget{token: token, loc: loc, def: defs[0]}, nil
+	}
+
+	// Several definitions: the token's qualifier may pick one by scope,
+	// as in Foo::bar when only one bar is in scope Foo.
+	line := lineAt(file, loc.Start.Line)
+	qualifier := qualifierBefore(line, byteColumn(line, loc.Start.Character))
+	if qualifier != "" {
+		var scoped []ctags.Tag
+		for _, def := range defs {
+			if _, path := index.SplitScope(def.Scope, def.Language); len(path) > 0 && path[len(path)-1] == qualifier {
+				scoped = append(scoped, def)
+			}
+		}
+		if len(scoped) == 1 {
+			return &renameTarget{token: token, loc: loc, def: scoped[0], qualifier: qualifier}, nil
+		}
+	}
+
+	var sites []string
+	for _, def := range defs {
+		sites = append(sites, fmt.Sprintf("%s:%d", s.displayPath(def.File), def.Line))
+	}
+	return nil, fmt.Errorf("cannot rename %s: it is ambiguous, with %d definitions (%s) not told apart by scope", token, len(defs), strings.Join(sites, ", "))
+}
+
+// validIdentifier reports whether name can replace an identifier in
+// lang.
+func validIdentifier(name, lang string) bool {
+	if name == "" {
+		return false
+	}
+	if r, _ := utf8.DecodeRuneInString(name); '0' <= r && r <= '9' {
+		return false
+	}
+	syn := syntaxOf(lang)
+	return strings.IndexFunc(name, func(r rune) bool { return !syn.isIdent(r) }) < 0
+}
+
+// renameEdit returns the edits renaming t to newName: its definition
+// and its identifier-accurate occurrences outside comments and strings.
+// If t is scoped and some occurrences lack its qualifier, it refuses
+// rather than return a partial edit, since those may be uses of t.
+func (s *LangSvc) renameEdit(ctx context.Context, t *renameTarget, docPath, newName string) (*lsp.WorkspaceEdit, error) {
+	ix := s.index()
+	occs, err := s.occurrences(ctx, ix, docPath, t.token, true)
+	if err != nil {
+		return nil, err
+	}
+
+	defCol := strings.Index(t.def.DefLinePrefix, t.def.Name)
+	changes := make(map[string][]lsp.TextEdit)
+	var skipped []string
+	for _, occ := range occs {
+		isDef := occ.File == t.def.File && occ.Range.Start.Line+1 == t.def.Line && occ.Col >= defCol
+		if t.qualifier != "" && !isDef && qualifierBefore(occ.Line, occ.Col) != t.qualifier {
+			skipped = append(skipped, fmt.Sprintf("%s:%d", occ.File, occ.Range.Start.Line+1))
+			continue
+		}
+		uri := "file://" + occ.File
+		changes[uri] = append(changes[uri], lsp.TextEdit{Range: occ.Range, NewText: newName})
+	}
+	if len(skipped) > 0 {
+		return nil, fmt.Errorf("cannot rename %s::%s: %d uses are not qualified by %s and may refer to it (%s)", t.qualifier, t.token, len(skipped), t.qualifier, strings.Join(skipped, ", "))
+	}
+	return &lsp.WorkspaceEdit{Changes: changes}, nil
+}
+
+func (s *LangSvc) PrepareRename(ctx context.Context, params *lsp.TextDocumentPositionParams, result *prepareRenameResult) error {
+	t, err := s.renameTarget(ctx, params)
+	if err != nil {
+		return err
+	}
+	*result = prepareRenameResult{Range: t.loc, Placeholder: t.token}
+	return nil
+}
+
+// renamedFiles returns the files touched by edit, for logging.
+func renamedFiles(edit *lsp.WorkspaceEdit) []string {
+	files := make([]string, 0, len(edit.Changes))
+	for uri := range edit.Changes {
+		files = append(files, strings.TrimPrefix(uri, "file://"))
+	}
+	sort.Strings(files)
+	return files
+}
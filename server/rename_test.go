@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// indexedLangSvc returns a LangSvc over a workspace at dir whose index
+// holds tags, so that tests need not run ctags.
+func indexedLangSvc(dir string, tags []ctags.Tag) *LangSvc {
+	ix := index.New()
+	ix.Add(tags)
+	ready := make(chan struct{})
+	close(ready)
+	s := newLangSvc(Config{})
+	s.RootPath = dir
+	s.workspace = &workspace{root: dir, ready: ready, index: ix}
+	return s
+}
+
+func TestRenameMultibyteLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rename-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.go")
+	src := "package a\n\nfunc count() int { return 0 }\n\nvar s, n = \"日本😀\", count() // ümlaut count\n"
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := indexedLangSvc(dir, []ctags.Tag{
+		{Name: "count", File: path, Line: 3, DefLinePrefix: "func count() int { return 0 }", Kind: "function", Language: "Go"},
+	})
+
+	var edit lsp.WorkspaceEdit
+	err = s.Rename(context.Background(), &lsp.RenameParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: "file://" + path},
+		Position:     lsp.Position{Line: 4, Character: 20}, // in count(), after "日本😀"
+		NewName:      "total",
+	}, &edit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Columns count UTF-16 code units: 日 and 本 are one each, and 😀
+	// is a surrogate pair.
+	want := map[string][]lsp.TextEdit{
+		"file://" + path: {
+			{Range: lsp.Range{Start: lsp.Position{Line: 2, Character: 5}, End: lsp.Position{Line: 2, Character: 10}}, NewText: "total"},
+			{Range: lsp.Range{Start: lsp.Position{Line: 4, Character: 19}, End: lsp.Position{Line: 4, Character: 24}}, NewText: "total"},
+		},
+	}
+	if !reflect.DeepEqual(edit.Changes, want) {
+		t.Errorf("got edits %+v\nwant %+v", edit.Changes, want)
+	}
+}
@@ -1,21 +1,40 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"time"
 
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/jsonrpc2"
-	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
 )
 
 type Config struct {
 	Mode    string
 	Addr    string
 	Logfile string
+
+	// IncludeCommentsAndStrings counts occurrences in comments and
+	// string literals as references. By default they are left out.
+	IncludeCommentsAndStrings bool
+
+	// Workers is the number of requests handled at once on each
+	// connection.
+	Workers int
+
+	// RequestTimeout cancels requests that take longer. Zero means no
+	// timeout.
+	RequestTimeout time.Duration
+
+	// AllowedOrigins lists the origins, such as
+	// "https://viewer.example.com", that browsers may open WebSockets
+	// or POST requests from, besides the server's own. "*" allows any origin. Empty
+	// allows only the same origin.
+	AllowedOrigins []string
 }
 
 func Serve(c Config) error {
@@ -28,8 +47,6 @@ func Serve(c Config) error {
 		log.SetOutput(io.MultiWriter(os.Stderr, f))
 	}
 
-	h := &jsonrpc2.LoggingHandler{Handler{}}
-
 	switch c.Mode {
 	case "tcp":
 		lis, err := net.Listen("tcp", c.Addr)
@@ -38,24 +55,57 @@ func Serve(c Config) error {
 		}
 		defer lis.Close()
 		log.Println("listening on", c.Addr)
-		return jsonrpc2.Serve(lis, h)
+		for {
+			nc, err := lis.Accept()
+			if err != nil {
+				return err
+			}
+			go func() {
+				defer nc.Close()
+				log.Printf("session opened for %s", nc.RemoteAddr())
+				svc := newLangSvc(c)
+				defer svc.close()
+				if err := newConn(nc, nc, Handler{svc}, c, false).serve(); err != nil {
+					log.Printf("! connection from %s: %s", nc.RemoteAddr(), err)
+				}
+				log.Printf("session closed for %s", nc.RemoteAddr())
+			}()
+		}
+
+	case "http":
+		log.Println("listening for HTTP on", c.Addr)
+		return http.ListenAndServe(c.Addr, newHTTPServer(c))
+
+	case "websocket":
+		log.Println("listening for WebSockets on", c.Addr)
+		return http.ListenAndServe(c.Addr, newWebSocketHandler(c))
 
 	case "stdio":
 		log.Println("reading on stdin, writing on stdout")
-		jsonrpc2.NewServerConn(os.Stdin, os.Stdout, h)
-		select {}
+		svc := newLangSvc(c)
+		defer svc.close()
+		return newConn(os.Stdin, os.Stdout, Handler{svc}, c, true).serve()
 
 	default:
 		return fmt.Errorf("invalid mode %q", c.Mode)
 	}
 }
 
-type Handler struct{}
+// Handler answers the requests of a session.
+type Handler struct {
+	svc *LangSvc
+}
 
-func (Handler) Handle(req *jsonrpc2.Request) (resp *jsonrpc2.Response) {
+// Handle handles req, returning nil for notifications. The method
+// implementing req is expected to stop early once ctx is done.
+func (h Handler) Handle(ctx context.Context, req *jsonrpc2.Request) (resp *jsonrpc2.Response) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("!!! PANIC recovered in Handle: %v", r)
+			if resp != nil {
+				resp.Result = nil
+				resp.Error = &jsonrpc2.Error{Code: codeInternalError, Message: fmt.Sprintf("panic: %v", r)}
+			}
 		}
 	}()
 
@@ -64,67 +114,32 @@ func (Handler) Handle(req *jsonrpc2.Request) (resp *jsonrpc2.Response) {
 	}
 
 	switch req.Method {
-	case "initialize":
-		var res lsp.InitializeResult
-		Server.Initialize(&lsp.InitializeParams{}, &res)
-		resp.SetResult(res)
-
-	case "shutdown":
-		// Result is undefined, per
+	case "shutdown", "exit", "initialized":
+		// Result is null, per
 		// https://github.com/Microsoft/language-server-protocol/blob/master/protocol.md#shutdown-request.
-		resp.SetResult(true)
-
-	case "textDocument/hover":
-		var params lsp.TextDocumentPositionParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			resp.Error = &jsonrpc2.Error{Code: 123, Message: "error!"}
-			return
-		}
-
-		pos := params.Position
-		resp.SetResult(lsp.Hover{
-			Contents: []lsp.MarkedString{{Language: "markdown", Value: "Hello CTags!"}},
-			Range: lsp.Range{
-				Start: lsp.Position{Line: pos.Line, Character: pos.Character - 3},
-				End:   lsp.Position{Line: pos.Line, Character: pos.Character + 3},
-			},
-		})
-
-	case "textDocument/documentSymbol":
-		var params lsp.DocumentSymbolParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			resp.Error = &jsonrpc2.Error{Code: 123, Message: "error!"}
-			return
-		}
-
-		var res []lsp.SymbolInformation
-		Server.DocumentSymbols(&params, &res)
-		resp.SetResult(res)
-
-	case "textDocument/definition":
-		var params lsp.TextDocumentPositionParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			resp.Error = &jsonrpc2.Error{Code: 123, Message: "error!"}
-			return
+		// The session stops taking requests after shutdown and acts
+		// on exit itself, and initialized needs no action. Exit and
+		// initialized are notifications, but are answered like
+		// shutdown if sent with an ID.
+		if resp != nil {
+			resp.SetResult(nil)
 		}
+		return
+	}
 
-		var res []lsp.Location
-		Server.GoToDefinition(&params, &res)
-		resp.SetResult(res)
-
-	case "textDocument/references":
-		var params lsp.ReferenceParams
-		if err := json.Unmarshal(*req.Params, &params); err != nil {
-			resp.Error = &jsonrpc2.Error{Code: 123, Message: "error!"}
-			return
+	res, err := dispatch(ctx, h.svc, req)
+	if resp == nil {
+		if err != nil {
+			log.Printf("! notification %s failed: %s", req.Method, err.Message)
 		}
-		var res []lsp.Location
-		Server.References(&params, &res)
-		resp.SetResult(res)
-
-	default:
-		log.Printf("! Unrecognized RPC call: %s", req.Method)
+		return
+	}
+	if err != nil {
+		resp.Error = err
+		return
+	}
+	if err := resp.SetResult(res); err != nil {
+		resp.Error = &jsonrpc2.Error{Code: codeInternalError, Message: err.Error()}
 	}
-
 	return
 }
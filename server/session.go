@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/jsonrpc2"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// session handles the JSON-RPC 2.0 messages of one client, whatever
+// the transport. Unlike jsonrpc2.ServerConn, it handles requests
+// concurrently, on a bounded number of workers, and each request runs
+// with a context that is cancelled by $/cancelRequest or when the
+// request times out.
+//
+// Notifications, initialize and shutdown are handled in the order they
+// arrive before handle returns, so that document changes apply before
+// the requests that follow them. $/cancelRequest is the exception: it
+// takes effect at once.
+type session struct {
+	h       Handler
+	timeout time.Duration
+	workers chan struct{} // holds a token per busy worker
+
+	// parent is set if the client started the server, as over stdio,
+	// and the session should end when the client's process does.
+	parent bool
+
+	// order serializes handle, and guards state, the lifecycle
+	// state.
+	order sync.Mutex
+	state int
+
+	mu      sync.Mutex
+	pending map[string]context.CancelFunc // by JSON-encoded request ID
+
+	wg sync.WaitGroup // requests in flight
+
+	quit     chan struct{} // closed by stop
+	stopOnce sync.Once
+	exitErr  error // why the session stopped; set before quit is closed
+}
+
+// newSession returns a session whose requests h handles. If parent is
+// set, the session stops when the process named by initialize exits.
+func newSession(h Handler, c Config, parent bool) *session {
+	workers := c.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	return &session{
+		h:       h,
+		timeout: c.RequestTimeout,
+		workers: make(chan struct{}, workers),
+		parent:  parent,
+		pending: make(map[string]context.CancelFunc),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Lifecycle states of a session, per
+// https://github.com/Microsoft/language-server-protocol/blob/master/protocol.md#initialize.
+const (
+	stateNew         = iota // waiting for initialize
+	stateInitialized        // serving requests
+	stateShutdown           // shutdown received, waiting for exit
+)
+
+// parentPollInterval is how often a session checks that the process
+// which started the server is still alive.
+const parentPollInterval = 5 * time.Second
+
+// stop ends the session with err, nil for a clean exit. Only the first
+// call has any effect.
+func (s *session) stop(err error) {
+	s.stopOnce.Do(func() {
+		s.exitErr = err
+		close(s.quit)
+	})
+}
+
+// close cancels the requests in flight and waits for them to finish.
+func (s *session) close() {
+	s.mu.Lock()
+	for _, cancel := range s.pending {
+		cancel()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// handle handles req, passing its response to reply exactly once if it
+// is a request, and never if it is a notification. Requests run with a
+// context derived from ctx. Reply may be called on another goroutine
+// after handle returns.
+func (s *session) handle(ctx context.Context, req *jsonrpc2.Request, reply func(*jsonrpc2.Response)) {
+	// Cancellation needs no ordering, and must not wait behind the
+	// notification that is holding order.
+	if req.Method == "$/cancelRequest" {
+		var params struct {
+			ID jsonrpc2.ID `json:"id"`
+		}
+		if req.Params != nil && json.Unmarshal(*req.Params, &params) == nil {
+			s.cancel(idKey(params.ID))
+		}
+		replyNull(req, reply)
+		return
+	}
+
+	s.order.Lock()
+	defer s.order.Unlock()
+
+	switch req.Method {
+	case "exit":
+		replyNull(req, reply)
+		if s.state != stateShutdown {
+			s.stop(errors.New("exit notification received before shutdown request"))
+			return
+		}
+		s.stop(nil)
+		return
+	}
+
+	switch {
+	case s.state == stateNew && req.Method != "initialize":
+		if !req.Notification {
+			reply(&jsonrpc2.Response{ID: req.ID, Error: &jsonrpc2.Error{Code: codeServerNotInitialized, Message: fmt.Sprintf("%s: server not initialized", req.Method)}})
+		}
+		return
+	case s.state != stateNew && req.Method == "initialize":
+		if !req.Notification {
+			reply(&jsonrpc2.Response{ID: req.ID, Error: &jsonrpc2.Error{Code: codeInvalidRequest, Message: "server already initialized"}})
+		}
+		return
+	case s.state == stateShutdown:
+		if !req.Notification {
+			reply(&jsonrpc2.Response{ID: req.ID, Error: &jsonrpc2.Error{Code: codeInvalidRequest, Message: fmt.Sprintf("%s: server is shutting down", req.Method)}})
+		}
+		return
+	}
+
+	var cancel context.CancelFunc
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	switch {
+	case req.Method == "initialize":
+		defer cancel()
+		if resp := s.run(ctx, req, reply); resp != nil && resp.Error == nil {
+			s.state = stateInitialized
+			if s.parent {
+				s.watchParent(req)
+			}
+		}
+		return
+	case req.Method == "shutdown":
+		defer cancel()
+		s.state = stateShutdown
+		s.run(ctx, req, reply)
+		return
+	case req.Notification:
+		defer cancel()
+		s.run(ctx, req, reply)
+		return
+	}
+
+	key := idKey(req.ID)
+	s.mu.Lock()
+	s.pending[key] = cancel
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.cancel(key)
+		select {
+		case s.workers <- struct{}{}:
+			defer func() { <-s.workers }()
+		case <-ctx.Done():
+			reply(&jsonrpc2.Response{ID: req.ID, Error: &jsonrpc2.Error{Code: codeRequestCancelled, Message: fmt.Sprintf("%s: %s", req.Method, ctx.Err())}})
+			return
+		}
+		s.run(ctx, req, reply)
+	}()
+}
+
+// watchParent stops the session once the process named in the
+// initialize request exits, so that servers orphaned by a crashed
+// editor do not pile up.
+func (s *session) watchParent(req *jsonrpc2.Request) {
+	var params lsp.InitializeParams
+	if req.Params == nil || json.Unmarshal(*req.Params, &params) != nil || params.ProcessID <= 0 {
+		return
+	}
+	pid := params.ProcessID
+	go func() {
+		t := time.NewTicker(parentPollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if !processAlive(pid) {
+					log.Printf("parent process %d exited", pid)
+					s.stop(fmt.Errorf("parent process %d exited", pid))
+					return
+				}
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+}
+
+// processAlive reports whether the process with the given pid is
+// running. Signal 0 checks for the process without disturbing it.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) != os.ErrProcessDone
+}
+
+// replyNull replies to req with a null result, unless req is a
+// notification. It answers requests for methods that are meant to be
+// notifications, such as exit, which clients may nonetheless send with
+// an ID.
+func replyNull(req *jsonrpc2.Request, reply func(*jsonrpc2.Response)) {
+	if req.Notification {
+		return
+	}
+	resp := &jsonrpc2.Response{ID: req.ID}
+	resp.SetResult(nil)
+	reply(resp)
+}
+
+// run handles req and replies with its response, if it has one, which
+// it also returns.
+func (s *session) run(ctx context.Context, req *jsonrpc2.Request, reply func(*jsonrpc2.Response)) *jsonrpc2.Response {
+	start := time.Now()
+	resp := s.h.Handle(ctx, req)
+	if resp == nil {
+		return nil
+	}
+	if resp.Error != nil {
+		log.Printf("<-- %s (%v): error: %s", req.Method, time.Since(start), resp.Error.Message)
+	} else {
+		log.Printf("<-- %s (%v)", req.Method, time.Since(start))
+	}
+	reply(resp)
+	return resp
+}
+
+// cancel cancels the pending request with the given key, if any.
+func (s *session) cancel(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.pending[key]; ok {
+		cancel()
+		delete(s.pending, key)
+	}
+}
+
+func idKey(id jsonrpc2.ID) string {
+	b, _ := json.Marshal(id)
+	return string(b)
+}
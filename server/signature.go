@@ -0,0 +1,180 @@
+package server
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/tag-server/ctags"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/lsp"
+)
+
+// noParenCalls lists the languages whose calls are not written as
+// callee(args...), for which there is no signature help.
+var noParenCalls = map[string]bool{
+	"Lisp":    true,
+	"Scheme":  true,
+	"Clojure": true,
+	"Sh":      true,
+	"Make":    true,
+	"Tcl":     true,
+}
+
+// callKeywords are words that are followed by parentheses without
+// being called.
+var callKeywords = map[string]bool{
+	"if":       true,
+	"elif":     true,
+	"for":      true,
+	"foreach":  true,
+	"while":    true,
+	"switch":   true,
+	"catch":    true,
+	"return":   true,
+	"sizeof":   true,
+	"typeof":   true,
+	"func":     true,
+	"function": true,
+	"def":      true,
+	"and":      true,
+	"or":       true,
+	"not":      true,
+	"in":       true,
+}
+
+// call is a call surrounding a position.
+type call struct {
+	callee    string
+	qualifier string
+	arg       int // index of the argument the position is in
+}
+
+// openBracket is a bracket that is still open during enclosingCall's
+// scan.
+type openBracket struct {
+	c      byte
+	off    int
+	commas int
+}
+
+// enclosingCall returns the innermost call whose argument list contains
+// offset off of text, skipping comments and strings.
+func enclosingCall(text string, off int, syn syntax) (call, bool) {
+	if off > len(text) {
+		off = len(text)
+	}
+	var stack []openBracket
+	for i := 0; i < off; {
+		if n := skipCommentOrString(text[i:off], syn); n > 0 {
+			i += n
+			continue
+		}
+		switch c := text[i]; c {
+		case '(', '[', '{':
+			stack = append(stack, openBracket{c: c, off: i})
+		case ')', ']', '}':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case ',':
+			if len(stack) > 0 {
+				stack[len(stack)-1].commas++
+			}
+		}
+		i++
+	}
+
+	for j := len(stack) - 1; j >= 0; j-- {
+		b := stack[j]
+		if b.c != '(' {
+			// A call's argument list does not extend past a block or
+			// index expression inside it.
+			if b.c == '{' {
+				break
+			}
+			continue
+		}
+		before := strings.TrimRight(text[:b.off], " \t")
+		start := strings.LastIndexFunc(before, func(r rune) bool { return !syn.isIdent(r) }) + 1
+		callee := before[start:]
+		if callee == "" || callKeywords[callee] {
+			continue
+		}
+		if r, _ := utf8.DecodeRuneInString(callee); '0' <= r && r <= '9' {
+			continue
+		}
+		lineStart := strings.LastIndex(before[:start], "\n") + 1
+		return call{
+			callee:    callee,
+			qualifier: qualifierBefore(before[lineStart:], start-lineStart),
+			arg:       b.commas,
+		}, true
+	}
+	return call{}, false
+}
+
+// splitParams splits a signature such as "(a int, b map[K, V])" into
+// its parameters, on the commas outside nested brackets.
+func splitParams(signature string) []string {
+	sig := strings.TrimSpace(signature)
+	if strings.HasPrefix(sig, "(") {
+		if end := matchingParen(sig); end > 0 {
+			sig = sig[1:end]
+		}
+	}
+	if strings.TrimSpace(sig) == "" {
+		return nil
+	}
+	var params []string
+	depth, start := 0, 0
+	for i := 0; i < len(sig); i++ {
+		switch sig[i] {
+		case '(', '[', '{', '<':
+			depth++
+		case ')', ']', '}', '>':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				params = append(params, strings.TrimSpace(sig[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(params, strings.TrimSpace(sig[start:]))
+}
+
+// matchingParen returns the index of the parenthesis closing the one
+// that s starts with, or -1.
+func matchingParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// signatureInformation returns the signature of tag.
+func (s *LangSvc) signatureInformation(tag ctags.Tag) lsp.SignatureInformation {
+	info := lsp.SignatureInformation{Label: tag.Name + tag.Signature}
+	if tag.Type != "" {
+		_, typ := splitTyperef(tag.Type)
+		info.Label += " " + typ
+	}
+	for _, p := range splitParams(tag.Signature) {
+		info.Parameters = append(info.Parameters, lsp.ParameterInformation{Label: p})
+	}
+	if contents, err := s.fetchFile("file://" + tag.File); err == nil {
+		info.Documentation = docComment(strings.Split(contents, "\n"), tag.Line, tag.Language)
+	}
+	return info
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/tag-server/index"
+)
+
+// maxWorkspaceSymbols caps the results of a workspace/symbol request.
+const maxWorkspaceSymbols = 100
+
+// symbolQuery is a parsed workspace/symbol query such as
+// "kind:function lang:go path:server/ Handle".
+type symbolQuery struct {
+	names  []string // words that must all fuzzy-match the symbol name
+	filter index.Filter
+	paths  []string // path prefixes, relative to the workspace root
+}
+
+func parseSymbolQuery(query string) symbolQuery {
+	var q symbolQuery
+	for _, field := range strings.Fields(query) {
+		i := strings.Index(field, ":")
+		if i <= 0 {
+			q.names = append(q.names, field)
+			continue
+		}
+		switch key, val := field[:i], field[i+1:]; key {
+		case "kind":
+			q.filter.Kinds = append(q.filter.Kinds, val)
+		case "lang", "language":
+			q.filter.Languages = append(q.filter.Languages, ctagsLanguage(val))
+		case "path", "file":
+			if strings.ContainsAny(val, "*?[") {
+				q.filter.File = val
+			} else {
+				q.paths = append(q.paths, val)
+			}
+		default:
+			// Not a filter, e.g. "Foo::bar".
+			q.names = append(q.names, field)
+		}
+	}
+	return q
+}
+
+// matchesNames reports whether name fuzzy-matches each of words,
+// ignoring case.
+func matchesNames(name string, words []string) bool {
+	name = strings.ToLower(name)
+	for _, word := range words {
+		rest := name
+		for _, r := range strings.ToLower(word) {
+			i := strings.IndexRune(rest, r)
+			if i < 0 {
+				return false
+			}
+			rest = rest[i+utf8.RuneLen(r):]
+		}
+	}
+	return true
+}
+
+// ctagsLanguage maps an LSP language identifier back to a ctags
+// language name. Names that are not known identifiers are returned
+// unchanged, and are compared to ctags names case-insensitively.
+func ctagsLanguage(id string) string {
+	for lang, langID := range languageIDs {
+		if strings.EqualFold(id, langID) {
+			return lang
+		}
+	}
+	return id
+}
+
+// matchesPaths reports whether file, relative to root, starts with one
+// of paths (or paths is empty).
+func matchesPaths(root, file string, paths []string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	rel := file
+	if root != "" {
+		if r, err := filepath.Rel(root, file); err == nil {
+			rel = r
+		}
+	}
+	rel = filepath.ToSlash(rel)
+	for _, p := range paths {
+		if strings.HasPrefix(rel, strings.TrimPrefix(p, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTestFile reports whether path looks like test code, by the naming
+// conventions of the common languages.
+func isTestFile(path string) bool {
+	path = filepath.ToSlash(path)
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	return strings.HasSuffix(stem, "_test") ||
+		strings.HasPrefix(stem, "test_") ||
+		strings.HasSuffix(stem, "Test") || strings.HasSuffix(stem, "Tests") ||
+		strings.HasSuffix(stem, ".spec") || strings.HasSuffix(stem, ".test") ||
+		strings.Contains(path, "/test/") || strings.Contains(path, "/tests/") ||
+		strings.Contains(path, "/__tests__/")
+}
+
+// bySymbolRank orders workspace symbol matches: exact matches before
+// prefix matches before looser ones, then shorter names first, then
+// non-test before test code.
+type bySymbolRank []index.Match
+
+func (m bySymbolRank) Len() int      { return len(m) }
+func (m bySymbolRank) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m bySymbolRank) Less(i, j int) bool {
+	a, b := m[i], m[j]
+	if a.Mode != b.Mode {
+		return a.Mode < b.Mode
+	}
+	if len(a.Name) != len(b.Name) {
+		return len(a.Name) < len(b.Name)
+	}
+	if ta, tb := isTestFile(a.File), isTestFile(b.File); ta != tb {
+		return tb
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	return a.Line < b.Line
+}
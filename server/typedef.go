@@ -0,0 +1,148 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+)
+
+// typeDefKinds are the tag kinds that define types, for typerefs that
+// do not say which kind of type they name.
+var typeDefKinds = map[string]bool{
+	"class":     true,
+	"interface": true,
+	"struct":    true,
+	"trait":     true,
+	"protocol":  true,
+	"enum":      true,
+	"union":     true,
+	"typedef":   true,
+	"type":      true,
+	"alias":     true,
+}
+
+// typeModifiers are words that qualify a type name without being part
+// of it.
+var typeModifiers = map[string]bool{
+	"const":    true,
+	"volatile": true,
+	"struct":   true,
+	"class":    true,
+	"enum":     true,
+	"union":    true,
+	"mut":      true,
+	"dyn":      true,
+	"impl":     true,
+	"chan":     true,
+	"unsigned": true,
+	"signed":   true,
+}
+
+// typeName returns the name of the type a typeref value refers to,
+// stripped of pointer, reference, slice, modifier and qualifier syntax:
+// "Config" for each of "*pkg.Config", "[]*Config", "const Config &",
+// "&'a mut Config" and "ns::Config<T>".
+func typeName(typ, lang string) string {
+	typ = strings.TrimSpace(typ)
+	if lang == "Go" {
+		// Channel directions.
+		typ = strings.Replace(typ, "<-", " ", -1)
+	}
+	for {
+		switch {
+		case strings.HasPrefix(typ, "map[") && lang == "Go":
+			// The value type of a map.
+			if i := matchingBracket(typ[3:]); i > 0 {
+				typ = typ[3+i+1:]
+				continue
+			}
+		case strings.HasPrefix(typ, "["):
+			if i := matchingBracket(typ); i > 0 {
+				typ = typ[i+1:]
+				continue
+			}
+		case strings.HasPrefix(typ, "..."):
+			typ = typ[3:]
+			continue
+		case strings.HasPrefix(typ, "'"):
+			// A Rust lifetime.
+			if i := strings.IndexAny(typ, " \t"); i > 0 {
+				typ = typ[i:]
+			} else {
+				typ = ""
+			}
+		}
+		trimmed := strings.Trim(typ, "*&^? \t")
+		if trimmed == typ {
+			break
+		}
+		typ = trimmed
+	}
+
+	// Drop modifiers, keeping the first word that is not one.
+	if i := strings.IndexAny(typ, "<([{"); i >= 0 {
+		typ = typ[:i]
+	}
+	var name string
+	for _, word := range strings.Fields(strings.NewReplacer("*", " ", "&", " ").Replace(typ)) {
+		if !typeModifiers[word] && !strings.HasPrefix(word, "'") {
+			name = word
+			break
+		}
+	}
+	if _, path := index.SplitScope(name, lang); len(path) > 0 {
+		name = path[len(path)-1]
+	}
+	return name
+}
+
+// matchingBracket returns the index of the bracket closing the one that
+// s starts with, or -1.
+func matchingBracket(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// typeDefinitions returns the definitions of the types of defs, per
+// their typerefs, nearest to each def first.
+func typeDefinitions(ix *index.Index, defs []ctags.Tag) []ctags.Tag {
+	var types []ctags.Tag
+	seen := make(map[string]bool)
+	for _, def := range defs {
+		if def.Type == "" {
+			continue
+		}
+		kind, typ := splitTyperef(def.Type)
+		name := typeName(typ, def.Language)
+		if name == "" {
+			continue
+		}
+		var candidates []ctags.Tag
+		for _, t := range ix.Lookup(name) {
+			if t.Kind == kind || kind != "struct" && kind != "union" && kind != "enum" && kind != "class" && typeDefKinds[t.Kind] {
+				candidates = append(candidates, t)
+			}
+		}
+		rankDefinitions(candidates, def.File, "", "", ix)
+		for _, t := range candidates {
+			key := t.File + ":" + t.Name + ":" + t.Kind
+			if !seen[key] {
+				seen[key] = true
+				types = append(types, t)
+			}
+		}
+	}
+	return types
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsStream carries one JSON-RPC message per WebSocket message.
+type wsStream struct {
+	ws *websocket.Conn
+}
+
+func (s wsStream) read() ([]byte, error) {
+	_, msg, err := s.ws.ReadMessage()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return nil, io.EOF
+	}
+	return msg, err
+}
+
+func (s wsStream) write(msg []byte) error {
+	return s.ws.WriteMessage(websocket.TextMessage, msg)
+}
+
+// newWebSocketHandler returns a handler that upgrades requests to
+// WebSockets and serves each as a session, like a TCP connection.
+// Browsers on origins other than the server's are refused unless
+// c.AllowedOrigins lists them.
+func newWebSocketHandler(c Config) http.Handler {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			if !originAllowed(c, r) {
+				log.Printf("! refused WebSocket from origin %q", r.Header.Get("Origin"))
+				return false
+			}
+			return true
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // Upgrade has replied with an error
+		}
+		defer ws.Close()
+		log.Printf("session opened for %s", r.RemoteAddr)
+		svc := newLangSvc(c)
+		defer svc.close()
+
+		conn := &conn{session: newSession(Handler{svc}, c, false), stream: wsStream{ws}}
+		err = conn.serve()
+		if err != nil {
+			log.Printf("! WebSocket from %s: %s", r.RemoteAddr, err)
+		}
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		log.Printf("session closed for %s", r.RemoteAddr)
+	})
+}
+
+// originAllowed reports whether c lets r through: requests from
+// non-browser clients, which send no Origin, from pages served by this
+// host, and from the origins in c.AllowedOrigins.
+func originAllowed(c Config, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(r) {
+		return true
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether r comes from a page served by this host,
+// as gorilla/websocket checks when no CheckOrigin is given.
+func sameOrigin(r *http.Request) bool {
+	u, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/tag-server/ctags"
+	"github.com/sourcegraph/tag-server/index"
+)
+
+// workspace is the symbol index of a root directory. It is built in
+// the background, so that initialize returns without waiting for
+// ctags.
+type workspace struct {
+	root  string
+	ready chan struct{} // closed once index and err are set
+	index *index.Index
+	err   error
+
+	refs int // sessions using the workspace, guarded by workspaces
+
+	// graph caches the inheritance graph of index until the index
+	// changes, since code lenses and hierarchy requests all need it.
+	graphMu sync.Mutex
+	graph   *inheritanceGraph
+
+	// retagged counts the re-tags of each saved file, so that the tags
+	// of an earlier save do not replace those of a later one.
+	retagMu  sync.Mutex
+	retagged map[string]uint64
+}
+
+// workspaces holds the workspaces in use by root, so that sessions on
+// the same root share one index.
+var workspaces = struct {
+	sync.Mutex
+	m map[string]*workspace
+}{m: make(map[string]*workspace)}
+
+// acquireWorkspace returns the workspace of root, starting to index it
+// if no session is using it yet. Callers release it when done.
+func acquireWorkspace(root string) *workspace {
+	workspaces.Lock()
+	defer workspaces.Unlock()
+	w := workspaces.m[root]
+	if w == nil {
+		w = newWorkspace(root)
+		workspaces.m[root] = w
+	}
+	w.refs++
+	return w
+}
+
+// release drops a reference to w. The last release forgets the
+// workspace, so the next session on its root indexes it afresh.
+func (w *workspace) release() {
+	workspaces.Lock()
+	defer workspaces.Unlock()
+	w.refs--
+	if w.refs == 0 {
+		delete(workspaces.m, w.root)
+		log.Printf("released index of %s", w.root)
+	}
+}
+
+func newWorkspace(root string) *workspace {
+	w := &workspace{root: root, ready: make(chan struct{})}
+	go func() {
+		defer close(w.ready)
+		w.index, w.err = index.OpenWorkspace(root)
+		if w.err != nil {
+			log.Printf("! indexing %s failed: %s", root, w.err)
+		}
+	}()
+	return w
+}
+
+// Index returns the workspace index, waiting up to timeout for it to
+// be built. It returns nil if the index is not ready in time or could
+// not be built.
+func (w *workspace) Index(timeout time.Duration) *index.Index {
+	select {
+	case <-w.ready:
+	case <-time.After(timeout):
+		log.Printf("! index of %s is not ready yet", w.root)
+		return nil
+	}
+	if w.err != nil {
+		return nil
+	}
+	return w.index
+}
+
+// inheritanceGraph returns the inheritance graph of the workspace
+// index, which must be ready, building it only if the index has changed
+// since it was last built.
+func (w *workspace) inheritanceGraph() *inheritanceGraph {
+	w.graphMu.Lock()
+	defer w.graphMu.Unlock()
+	if w.graph == nil || w.graph.gen != w.index.Generation() {
+		w.graph = newInheritanceGraph(w.index)
+	}
+	return w.graph
+}
+
+// startRetag records that path is being re-tagged and returns the
+// number of the re-tag, to pass to finishRetag.
+func (w *workspace) startRetag(path string) uint64 {
+	w.retagMu.Lock()
+	defer w.retagMu.Unlock()
+	if w.retagged == nil {
+		w.retagged = make(map[string]uint64)
+	}
+	w.retagged[path]++
+	return w.retagged[path]
+}
+
+// finishRetag replaces the tags of path in the index, which must be
+// ready, with tags, unless a later re-tag of path has started since
+// re-tag gen. It reports whether it replaced them.
+func (w *workspace) finishRetag(path string, gen uint64, tags []ctags.Tag) bool {
+	w.retagMu.Lock()
+	defer w.retagMu.Unlock()
+	if w.retagged[path] != gen {
+		return false
+	}
+	w.index.Replace(path, tags)
+	return true
+}
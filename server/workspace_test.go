@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/sourcegraph/tag-server/ctags"
+)
+
+func TestRetagKeepsLatest(t *testing.T) {
+	s := indexedLangSvc("/src", nil)
+	w := s.workspace
+
+	first := w.startRetag("/src/a.py")
+	second := w.startRetag("/src/a.py")
+	if !w.finishRetag("/src/a.py", second, []ctags.Tag{{Name: "new", File: "/src/a.py", Line: 1}}) {
+		t.Fatal("latest re-tag was dropped")
+	}
+	if w.finishRetag("/src/a.py", first, []ctags.Tag{{Name: "old", File: "/src/a.py", Line: 1}}) {
+		t.Error("stale re-tag replaced the tags of a later one")
+	}
+	if tags := w.index.Lookup("old"); len(tags) != 0 {
+		t.Errorf("stale tags indexed: %v", tags)
+	}
+	if tags := w.index.Lookup("new"); len(tags) != 1 {
+		t.Errorf("got %v for new, want its tag", tags)
+	}
+}